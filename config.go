@@ -1,15 +1,30 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/styles"
 
 	"github.com/pelletier/go-toml"
 )
 
+// RemoteConfig is a named remote the journal can be published to, in addition
+// to site.remote. The Link and Releases override site.link and site.releases
+// when publishing to the remote, if set.
+type RemoteConfig struct {
+	URL      string
+	Link     string
+	Releases int
+}
+
 type Config struct {
 	f *os.File
 
@@ -19,6 +34,10 @@ type Config struct {
 		Link        string
 		Remote      string
 		Theme       string
+		Paginate    int
+		Template    string
+		Robots      bool
+		Releases    int
 		Blogroll    []string
 	}
 
@@ -26,6 +45,28 @@ type Config struct {
 		Name  string
 		Email string
 	}
+
+	Markdown struct {
+		Footnotes        bool
+		DefinitionLists  bool
+		Typographer      bool
+		Linkify          bool `default:"true"`
+		HardWraps        bool
+		Unsafe           bool   `default:"true"`
+		HeadingIDs       string `default:"auto"`
+		HighlightStyle   string
+		HighlightClasses bool
+	}
+
+	Feed struct {
+		Atom       string
+		RSS        string
+		Content    bool
+		Categories bool
+		Tags       bool
+	}
+
+	Remote map[string]RemoteConfig `toml:",omitempty"`
 }
 
 var (
@@ -38,13 +79,42 @@ description = ""
 link        = ""
 remote      = ""
 theme       = ""
+paginate    = 0
+template    = "html"
+robots      = false
+releases    = 0
 blogroll    = []
 
 [author]
 name  = ""
 email = ""
+
+[markdown]
+footnotes        = false
+definitionLists  = false
+typographer      = false
+linkify          = true
+hardWraps        = false
+unsafe           = true
+headingIDs       = "auto"
+highlightStyle   = ""
+highlightClasses = false
+
+[feed]
+atom       = ""
+rss        = ""
+content    = false
+categories = false
+tags       = false
+
+# [remote.staging]
+# url      = ""
+# link     = ""
+# releases = 0
 `
 
+	reremote = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
 	ConfigCmd = &Command{
 		Usage: "config <key> <value>",
 		Short: "set a configuration value within jrnl.toml",
@@ -52,7 +122,55 @@ email = ""
 jrnl.toml file. For all properties in that file, this will simply overwrite
 what's already there, except for the site.blogroll property which will append
 the given value to the pre-existing blogroll. If an empty string is given to
-site.blogroll then this will clear down the list.`,
+site.blogroll then this will clear down the list.
+
+The site.paginate property is the number of posts to display on each page of
+the site, category, and tag indexes. If this is 0 then every post will be
+displayed on a single page.
+
+The site.template property determines how layouts are executed. By default
+this is html, meaning any values placed in a layout will be escaped according
+to their context. This can be set to text for layouts that rely on values not
+being escaped.
+
+The site.robots property determines whether a robots.txt file referencing the
+generated sitemap.xml file should be written when publishing. The sitemap is
+only generated if site.link is set.
+
+The site.releases property is the number of releases to keep on the remote
+when deploying atomically. If this is 0 then files are copied to the remote
+directly. Otherwise, each publish is deployed to a new release directory on the
+remote, and the current symlink on the remote is pointed to it once every file
+has been copied. This is only supported for file and sftp remotes.
+
+The markdown properties configure how the Markdown of pages and posts is
+rendered. The markdown.footnotes, markdown.definitionLists,
+markdown.typographer, markdown.linkify, and markdown.hardWraps properties
+toggle the respective Markdown extensions. If markdown.unsafe is false then
+any raw HTML in the Markdown will be omitted. The markdown.headingIDs property
+determines how headings are given IDs, this can be auto to generate them from
+the heading text, attribute to only use the IDs given via {#id} attributes, or
+none.
+
+The markdown.highlightStyle property is the name of the style to use for
+highlighting fenced code blocks. If this is empty then code blocks will not be
+highlighted. If markdown.highlightClasses is true then the highlighted code
+will use CSS classes instead of inline styles, the CSS for which can be
+generated with the highlight command.
+
+The feed.atom and feed.rss properties are the files in the _site directory to
+write the Atom and RSS feeds of the journal to when publishing, such as
+atom.xml. If feed.content is true then each item in the feeds will contain the
+full content of the post, along with its summary. If feed.categories is true
+then a feed is written for each category too, to the files of the same name in
+the directory of the category. Likewise, if feed.tags is true then a feed is
+written for each tag to the directory of the tag.
+
+The remote.<name>.url, remote.<name>.link, and remote.<name>.releases
+properties configure a named remote that can be published to with the -to
+flag of the publish command. The link and releases of a named remote override
+site.link and site.releases when publishing to it, unless they are empty or 0.
+Named remotes can also be managed with the remote command.`,
 		Run: configCmd,
 	}
 )
@@ -65,6 +183,10 @@ func CreateConfig(dir string) (*Config, error) {
 	}
 
 	c := &Config{}
+	c.Site.Template = "html"
+	c.Markdown.Linkify = true
+	c.Markdown.Unsafe = true
+	c.Markdown.HeadingIDs = "auto"
 
 	if err := toml.NewEncoder(f).Encode(c); err != nil {
 		return nil, err
@@ -89,6 +211,25 @@ func OpenConfig() (*Config, error) {
 	return cfg, err
 }
 
+func setBool(b *bool, key, val string) error {
+	v, err := strconv.ParseBool(val)
+
+	if err != nil {
+		return errors.New(key + " must be true or false")
+	}
+
+	*b = v
+	return nil
+}
+
+// checkFeedFile checks that the given feed file is within the _site directory.
+func checkFeedFile(key, val string) error {
+	if filepath.IsAbs(val) || strings.HasPrefix(filepath.Clean(val), "..") {
+		return errors.New(key + " must be a file in the _site directory")
+	}
+	return nil
+}
+
 func (c *Config) Set(key, val string) error {
 	switch key {
 	case "site.title":
@@ -101,6 +242,27 @@ func (c *Config) Set(key, val string) error {
 		c.Site.Remote = val
 	case "site.theme":
 		c.Site.Theme = val
+	case "site.paginate":
+		n, err := strconv.Atoi(val)
+
+		if err != nil {
+			return errors.New("site.paginate must be a number")
+		}
+		c.Site.Paginate = n
+	case "site.robots":
+		return setBool(&c.Site.Robots, key, val)
+	case "site.releases":
+		n, err := strconv.Atoi(val)
+
+		if err != nil || n < 0 {
+			return errors.New("site.releases must be a number")
+		}
+		c.Site.Releases = n
+	case "site.template":
+		if val != "html" && val != "text" {
+			return errors.New("site.template must be either html or text")
+		}
+		c.Site.Template = val
 	case "site.blogroll":
 		if val == "" {
 			c.Site.Blogroll = []string{}
@@ -111,12 +273,134 @@ func (c *Config) Set(key, val string) error {
 		c.Author.Name = val
 	case "author.email":
 		c.Author.Email = val
+	case "markdown.footnotes":
+		return setBool(&c.Markdown.Footnotes, key, val)
+	case "markdown.definitionLists":
+		return setBool(&c.Markdown.DefinitionLists, key, val)
+	case "markdown.typographer":
+		return setBool(&c.Markdown.Typographer, key, val)
+	case "markdown.linkify":
+		return setBool(&c.Markdown.Linkify, key, val)
+	case "markdown.hardWraps":
+		return setBool(&c.Markdown.HardWraps, key, val)
+	case "markdown.unsafe":
+		return setBool(&c.Markdown.Unsafe, key, val)
+	case "markdown.headingIDs":
+		if val != "auto" && val != "attribute" && val != "none" {
+			return errors.New("markdown.headingIDs must be either auto, attribute, or none")
+		}
+		c.Markdown.HeadingIDs = val
+	case "markdown.highlightStyle":
+		if _, ok := styles.Registry[val]; val != "" && !ok {
+			return errors.New("unknown highlight style " + val)
+		}
+		c.Markdown.HighlightStyle = val
+	case "markdown.highlightClasses":
+		return setBool(&c.Markdown.HighlightClasses, key, val)
+	case "feed.atom":
+		if err := checkFeedFile(key, val); err != nil {
+			return err
+		}
+		c.Feed.Atom = val
+	case "feed.rss":
+		if err := checkFeedFile(key, val); err != nil {
+			return err
+		}
+		c.Feed.RSS = val
+	case "feed.content":
+		return setBool(&c.Feed.Content, key, val)
+	case "feed.categories":
+		return setBool(&c.Feed.Categories, key, val)
+	case "feed.tags":
+		return setBool(&c.Feed.Tags, key, val)
+	default:
+		if strings.HasPrefix(key, "remote.") {
+			return c.setRemote(key, val)
+		}
+		return errors.New("unknown configuration key")
+	}
+	return nil
+}
+
+func (c *Config) setRemote(key, val string) error {
+	parts := strings.Split(key, ".")
+
+	if len(parts) != 3 {
+		return errors.New("unknown configuration key")
+	}
+
+	name := parts[1]
+
+	if !reremote.MatchString(name) {
+		return errors.New("invalid remote name " + name)
+	}
+
+	if c.Remote == nil {
+		c.Remote = make(map[string]RemoteConfig)
+	}
+
+	r := c.Remote[name]
+
+	switch parts[2] {
+	case "url":
+		r.URL = val
+	case "link":
+		r.Link = val
+	case "releases":
+		n, err := strconv.Atoi(val)
+
+		if err != nil || n < 0 {
+			return errors.New(key + " must be a number")
+		}
+		r.Releases = n
 	default:
 		return errors.New("unknown configuration key")
 	}
+
+	c.Remote[name] = r
 	return nil
 }
 
+// UseRemote sets site.remote to the URL of the given named remote, and
+// overrides site.link and site.releases with those of the remote if set. If
+// the name is empty then the configuration is left as is.
+func (c *Config) UseRemote(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	r, ok := c.Remote[name]
+
+	if !ok {
+		return errors.New("unknown remote " + name)
+	}
+
+	if r.URL == "" {
+		return errors.New("remote " + name + " has no url, set with 'config remote." + name + ".url'")
+	}
+
+	c.Site.Remote = r.URL
+
+	if r.Link != "" {
+		c.Site.Link = r.Link
+	}
+
+	if r.Releases > 0 {
+		c.Site.Releases = r.Releases
+	}
+	return nil
+}
+
+// Hash returns the hash of the configuration that affects how pages and posts
+// are rendered.
+func (c *Config) Hash() []byte {
+	sha256 := sha256.New()
+	fmt.Fprintln(sha256, c.Site.Title, c.Site.Description, c.Site.Link, c.Site.Template)
+	fmt.Fprintln(sha256, c.Author.Name, c.Author.Email)
+	fmt.Fprintf(sha256, "%+v\n", c.Markdown)
+	return sha256.Sum(nil)
+}
+
 func (c *Config) Close() error { return c.f.Close() }
 
 func (c *Config) Save() error {
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type disk struct {
+	path string
+}
+
+func init() {
+	RegisterFS("file", openDisk)
+}
+
+// openDisk opens the directory on disk for the given file:// URL.
+func openDisk(u *url.URL) (FS, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, errors.New("unsupported host in remote url: " + u.Host)
+	}
+
+	if u.Path == "" {
+		return nil, errors.New("missing path in remote url")
+	}
+	return &disk{path: filepath.Clean(filepath.FromSlash(u.Path))}, nil
+}
+
+func (d *disk) filepath(path string) string { return filepath.Join(d.path, path) }
+
+func (d *disk) Open(path string) (File, error) {
+	path = d.filepath(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(0644))
+}
+
+func (d *disk) Remove(path string) error {
+	path = d.filepath(path)
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	parts := strings.Split(filepath.Dir(path), string(os.PathSeparator))
+
+	for i := range parts {
+		dir := string(os.PathSeparator) + filepath.Join(parts[:len(parts)-i]...)
+
+		if dir == d.path {
+			break
+		}
+
+		info, err := ioutil.ReadDir(dir)
+
+		if err != nil {
+			return err
+		}
+
+		if len(info) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *disk) Close() error { return nil }
+
+func (d *disk) ReadFile(path string) ([]byte, error) { return ioutil.ReadFile(d.filepath(path)) }
+
+func (d *disk) Link(oldpath, newpath string) error {
+	newpath = d.filepath(newpath)
+
+	if err := os.MkdirAll(filepath.Dir(newpath), os.FileMode(0755)); err != nil {
+		return err
+	}
+	return os.Link(d.filepath(oldpath), newpath)
+}
+
+func (d *disk) Symlink(target, path string) error {
+	path = d.filepath(path)
+	tmp := path + ".tmp"
+
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *disk) Readlink(path string) (string, error) { return os.Readlink(d.filepath(path)) }
+
+func (d *disk) ReadDir(path string) ([]string, error) {
+	info, err := ioutil.ReadDir(d.filepath(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(info))
+
+	for _, fi := range info {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+func (d *disk) RemoveAll(path string) error { return os.RemoveAll(d.filepath(path)) }
+
+func (d *disk) MkdirAll(path string) error {
+	return os.MkdirAll(d.filepath(path), os.FileMode(0755))
+}
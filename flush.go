@@ -10,7 +10,7 @@ var FlushCmd = &Command{
 	Usage: "flush",
 	Short: "clear the journal's hashed content",
 	Long: `Flush will remove the _data/hash file that contains the generated hashes of
-the pages and posts.`,
+the pages and posts, along with the hash of each named remote.`,
 	Run: flushCmd,
 }
 
@@ -20,10 +20,19 @@ func flushCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
-	if err := os.Remove(filepath.Join(dataDir, "hash")); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+	hashes, err := filepath.Glob(filepath.Join(remoteDataDir("*"), "hash"))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	for _, hash := range append(hashes, filepath.Join(dataDir, "hash")) {
+		if err := os.Remove(hash); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+				os.Exit(1)
+			}
 		}
 	}
 }
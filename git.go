@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gitFS is an FS that commits the files copied to it to a branch of a git
+// repository. Files are written to the repository as blobs when copied, and
+// are committed to the branch, on top of the branch's existing tree, when the
+// FS is closed. This requires git to be installed.
+type gitFS struct {
+	dir    string // dir is the repository the branch is committed to.
+	branch string
+	push   string // push is where to push the branch to after committing, if anywhere.
+
+	mu      sync.Mutex
+	changes map[string]string // changes maps each path to its blob, or "" if removed.
+}
+
+// gitFile is a file being written to a git repository. The blob for the file
+// is written when the file is closed.
+type gitFile struct {
+	bytes.Buffer
+
+	fs   *gitFS
+	path string
+}
+
+const gitZeroHash = "0000000000000000000000000000000000000000"
+
+var reunsafe = regexp.MustCompile("[^a-zA-Z0-9.-]+")
+
+func init() {
+	RegisterFS("git+file", openGit)
+	RegisterFS("git+ssh", openGit)
+	RegisterFS("git+https", openGit)
+	RegisterFS("git+http", openGit)
+}
+
+// openGit opens the git repository for the given URL. The fragment of the URL
+// is the branch to commit to, by default gh-pages. A git+file:// URL refers to
+// a repository on disk, which can be pushed to one of its remotes after
+// committing via the push query parameter. Any other URL refers to a remote
+// repository, the branch of which is fetched into a bare repository in the
+// _data/git directory, committed to, and then pushed back.
+func openGit(u *url.URL) (FS, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git is required for git remotes")
+	}
+
+	fs := &gitFS{
+		branch:  u.Fragment,
+		changes: make(map[string]string),
+	}
+
+	if fs.branch == "" {
+		fs.branch = "gh-pages"
+	}
+
+	if u.Scheme == "git+file" {
+		if u.Path == "" {
+			return nil, errors.New("missing path in remote url")
+		}
+
+		fs.dir = filepath.FromSlash(u.Path)
+		fs.push = u.Query().Get("push")
+
+		if _, err := fs.git(nil, "rev-parse", "--git-dir"); err != nil {
+			return nil, err
+		}
+		return fs, nil
+	}
+
+	remote := *u
+	remote.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	remote.Fragment = ""
+	remote.RawQuery = ""
+
+	fs.dir = filepath.Join(dataDir, "git", strings.Trim(reunsafe.ReplaceAllString(remote.Host+remote.Path, "-"), "-"))
+	fs.push = remote.String()
+
+	if _, err := os.Stat(fs.dir); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(fs.dir, os.FileMode(0755)); err != nil {
+			return nil, err
+		}
+
+		if _, err := fs.git(nil, "init", "--bare", "-q"); err != nil {
+			return nil, err
+		}
+	}
+
+	heads, err := fs.git(nil, "ls-remote", "--heads", fs.push, fs.branch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if heads != "" {
+		if _, err := fs.git(nil, "fetch", "-q", fs.push, "+refs/heads/"+fs.branch+":refs/heads/"+fs.branch); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// git runs git in the repository with the given arguments, and returns the
+// trimmed output. The given environment variables are added to the
+// environment of git.
+func (g *gitFS) git(env []string, args ...string) (string, error) {
+	return g.gitInput(nil, env, args...)
+}
+
+func (g *gitFS) gitInput(stdin []byte, env []string, args ...string) (string, error) {
+	b, err := g.gitRaw(stdin, env, args...)
+
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// gitRaw runs git in the repository with the given arguments, and returns the
+// output as is.
+func (g *gitFS) gitRaw(stdin []byte, env []string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", append([]string{"-C", g.dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// ident returns the environment variables for the identity of the commit if
+// git has not been configured with one.
+func (g *gitFS) ident() []string {
+	if _, err := g.git(nil, "config", "user.email"); err == nil {
+		return nil
+	}
+
+	if os.Getenv("GIT_COMMITTER_EMAIL") != "" || os.Getenv("EMAIL") != "" {
+		return nil
+	}
+
+	return []string{
+		"GIT_AUTHOR_NAME=jrnl",
+		"GIT_AUTHOR_EMAIL=jrnl@localhost",
+		"GIT_COMMITTER_NAME=jrnl",
+		"GIT_COMMITTER_EMAIL=jrnl@localhost",
+	}
+}
+
+func (g *gitFS) Open(path string) (File, error) {
+	return &gitFile{
+		fs:   g,
+		path: strings.TrimPrefix(filepath.ToSlash(path), "/"),
+	}, nil
+}
+
+// ReadFile returns the contents of the given file as it is on the branch,
+// ignoring any changes that have not been committed yet.
+func (g *gitFS) ReadFile(path string) ([]byte, error) {
+	object := "refs/heads/" + g.branch + ":" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+
+	if _, err := g.git(nil, "rev-parse", "-q", "--verify", object); err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	return g.gitRaw(nil, nil, "cat-file", "blob", object)
+}
+
+func (g *gitFS) Remove(path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.changes[strings.TrimPrefix(filepath.ToSlash(path), "/")] = ""
+	return nil
+}
+
+// Close commits the files copied to the repository to the branch, and pushes
+// the branch if configured to do so. Nothing is committed if the tree of the
+// branch would not change.
+func (g *gitFS) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.changes) == 0 {
+		return nil
+	}
+
+	ref := "refs/heads/" + g.branch
+
+	parent, err := g.git(nil, "rev-parse", "-q", "--verify", ref+"^{commit}")
+
+	if err != nil {
+		parent = ""
+	}
+
+	index, err := ioutil.TempFile("", "jrnl-git-index")
+
+	if err != nil {
+		return err
+	}
+
+	index.Close()
+
+	// The index has to not exist for git to create it.
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	if parent != "" {
+		if _, err := g.git(env, "read-tree", parent); err != nil {
+			return err
+		}
+	} else {
+		if _, err := g.git(env, "read-tree", "--empty"); err != nil {
+			return err
+		}
+	}
+
+	var info bytes.Buffer
+
+	for path, blob := range g.changes {
+		if blob == "" {
+			fmt.Fprintf(&info, "0 %s\t%s\n", gitZeroHash, path)
+			continue
+		}
+		fmt.Fprintf(&info, "100644 %s\t%s\n", blob, path)
+	}
+
+	if _, err := g.gitInput(info.Bytes(), env, "update-index", "--index-info"); err != nil {
+		return err
+	}
+
+	tree, err := g.git(env, "write-tree")
+
+	if err != nil {
+		return err
+	}
+
+	args := []string{"commit-tree", tree, "-m", "Publish " + time.Now().UTC().Format(time.RFC3339)}
+
+	if parent != "" {
+		if prev, err := g.git(nil, "rev-parse", parent+"^{tree}"); err == nil && prev == tree {
+			g.changes = make(map[string]string)
+			return nil
+		}
+		args = append(args, "-p", parent)
+	}
+
+	commit, err := g.git(g.ident(), args...)
+
+	if err != nil {
+		return err
+	}
+
+	old := parent
+
+	if old == "" {
+		old = gitZeroHash
+	}
+
+	if _, err := g.git(nil, "update-ref", ref, commit, old); err != nil {
+		return err
+	}
+
+	g.changes = make(map[string]string)
+
+	if g.push != "" {
+		if _, err := g.git(nil, "push", "-q", g.push, ref+":"+ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the contents of the file to the repository as a blob.
+func (f *gitFile) Close() error {
+	blob, err := f.fs.gitInput(f.Bytes(), nil, "hash-object", "-w", "--stdin")
+
+	if err != nil {
+		return err
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	f.fs.changes[f.path] = blob
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("git %s: %s\n", strings.Join(args, " "), out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func Test_Git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "jrnl-git")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "site.git")
+
+	if out, err := exec.Command("git", "init", "-q", "--bare", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s\n", out)
+	}
+
+	u, err := url.Parse("git+file://" + filepath.ToSlash(repo) + "#pages")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := remotefs["git+file"](u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, fs, map[string]string{
+		"/index.html":             "<html></html>",
+		"/assets/style.css":       "body {}",
+		"/programming/index.html": "<html>programming</html>",
+	})
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := gitOutput(t, repo, "ls-tree", "-r", "--name-only", "pages")
+
+	expected := "assets/style.css\nindex.html\nprogramming/index.html"
+
+	if files != expected {
+		t.Fatalf("unexpected files on branch, expected=%q, got=%q\n", expected, files)
+	}
+
+	first := gitOutput(t, repo, "rev-parse", "pages")
+
+	fs, err = remotefs["git+file"](u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, fs, map[string]string{
+		"/index.html": "<html>updated</html>",
+	})
+
+	if err := fs.Remove("/programming/index.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files = gitOutput(t, repo, "ls-tree", "-r", "--name-only", "pages")
+
+	expected = "assets/style.css\nindex.html"
+
+	if files != expected {
+		t.Fatalf("unexpected files on branch, expected=%q, got=%q\n", expected, files)
+	}
+
+	if body := gitOutput(t, repo, "show", "pages:index.html"); body != "<html>updated</html>" {
+		t.Fatalf("unexpected contents of %q, expected=%q, got=%q\n", "index.html", "<html>updated</html>", body)
+	}
+
+	if parent := gitOutput(t, repo, "rev-parse", "pages^"); parent != first {
+		t.Fatalf("unexpected parent commit, expected=%q, got=%q\n", first, parent)
+	}
+
+	second := gitOutput(t, repo, "rev-parse", "pages")
+
+	// Copying the same files again should not create an empty commit.
+	fs, err = remotefs["git+file"](u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, fs, map[string]string{
+		"/index.html": "<html>updated</html>",
+	})
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if head := gitOutput(t, repo, "rev-parse", "pages"); head != second {
+		t.Fatalf("unexpected commit for unchanged tree, expected=%q, got=%q\n", second, head)
+	}
+}
@@ -3,12 +3,16 @@ module github.com/andrewpillar/jrnl
 go 1.15
 
 require (
+	github.com/alecthomas/chroma/v2 v2.2.0
 	github.com/google/btree v1.0.0
 	github.com/gorilla/feeds v1.1.1
 	github.com/grokify/html-strip-tags-go v0.0.1
 	github.com/pelletier/go-toml v1.8.1
 	github.com/pkg/sftp v1.12.0
-	github.com/yuin/goldmark v1.3.0
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	github.com/yuin/goldmark v1.4.15
+	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.10.0
+	golang.org/x/term v0.15.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
 	"io"
 	"os"
@@ -17,24 +18,85 @@ type Hasher interface {
 	Hash() []byte
 }
 
-func OpenHash() (*Hash, error) {
-	f, err := os.OpenFile(filepath.Join(dataDir, "hash"), os.O_CREATE|os.O_RDWR, os.FileMode(0644))
+// Dependencies is the hash of an output of the journal, such as a page or
+// post, combined with the hashes of everything the output depends on, such as
+// the layouts it is rendered with and the configuration of the journal. If any
+// of these change then the output will be considered modified.
+type Dependencies struct {
+	Hasher
+
+	Sums [][]byte
+}
+
+func (d Dependencies) Hash() []byte {
+	sha256 := sha256.New()
+	sha256.Write(d.Hasher.Hash())
+
+	for _, sum := range d.Sums {
+		sha256.Write(sum)
+	}
+	return sha256.Sum(nil)
+}
+
+// OpenHash opens the hash file in the given directory. This is the _data
+// directory for the hash of site.remote, and a directory beneath _data/remote
+// for the hash of each named remote.
+func OpenHash(dir string) (*Hash, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "hash"), os.O_CREATE|os.O_RDWR, os.FileMode(0644))
 
 	if err != nil {
 		return nil, err
 	}
 
-	set := make(map[string][]byte)
+	h := &Hash{
+		f:   f,
+		set: make(map[string][]byte),
+	}
 
-	if err := gob.NewDecoder(f).Decode(&set); err != nil {
+	if err := gob.NewDecoder(f).Decode(&h.set); err != nil {
 		if err != io.EOF {
+			f.Close()
 			return nil, err
 		}
 	}
+	return h, nil
+}
+
+// ReadHash reads the hash file in the given directory into a Hash that is held
+// in memory only, as returned by NewHash. Neither the directory nor the file
+// are created if they do not exist, so the _data directory is left untouched.
+func ReadHash(dir string) (*Hash, error) {
+	h := NewHash()
+
+	f, err := os.Open(filepath.Join(dir, "hash"))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(&h.set); err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// NewHash returns a Hash that is held in memory only, and is not backed by the
+// _data/hash file.
+func NewHash() *Hash {
 	return &Hash{
-		f:   f,
-		set: set,
-	}, nil
+		set: make(map[string][]byte),
+	}
 }
 
 func (h *Hash) Get(key string) ([]byte, bool) {
@@ -54,6 +116,10 @@ func (h *Hash) Put(key string, hs Hasher) bool {
 	return false
 }
 
+// Reset clears the hash, so everything put into it afterwards is considered
+// modified.
+func (h *Hash) Reset() { h.set = make(map[string][]byte) }
+
 func (h *Hash) Delete(key string) {
 	if _, ok := h.set[key]; ok {
 		delete(h.set, key)
@@ -71,4 +137,9 @@ func (h *Hash) Save() error {
 	return gob.NewEncoder(h.f).Encode(h.set)
 }
 
-func (h *Hash) Close() error { return h.f.Close() }
+func (h *Hash) Close() error {
+	if h.f == nil {
+		return nil
+	}
+	return h.f.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+var HighlightCmd = &Command{
+	Usage: "highlight [options] [style]",
+	Short: "write the CSS for highlighting code blocks",
+	Long: `Highlight will write the CSS for the given highlight style to the
+_site/assets/highlight.css file. If no style is given then the style set via
+markdown.highlightStyle is used. This CSS is only needed if
+markdown.highlightClasses is set to true, otherwise the styles are inlined in
+the highlighted code.
+
+The -o flag can be given to specify a different file to write the CSS to.
+
+The -l flag will list the available highlight styles.`,
+	Run: highlightCmd,
+}
+
+func highlightCmd(cmd *Command, args []string) {
+	if err := initialized(""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	var (
+		out  string
+		list bool
+	)
+
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.StringVar(&out, "o", filepath.Join(assetsDir, "highlight.css"), "the file to write the CSS to")
+	fs.BoolVar(&list, "l", false, "list the available highlight styles")
+	fs.Parse(args[1:])
+
+	if list {
+		for _, name := range styles.Names() {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	cfg, err := OpenConfig()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	name := cfg.Markdown.HighlightStyle
+
+	if fsargs := fs.Args(); len(fsargs) >= 1 {
+		name = fsargs[0]
+	}
+
+	if name == "" {
+		fmt.Fprintf(os.Stderr, "%s %s: no highlight style, set with '%s config markdown.highlightStyle'\n", cmd.Argv0, args[0], cmd.Argv0)
+		os.Exit(1)
+	}
+
+	style, ok := styles.Registry[name]
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s %s: no such highlight style %q\n", cmd.Argv0, args[0], name)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), os.FileMode(0755)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	f, err := os.Create(out)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	defer f.Close()
+
+	if err := html.New(html.WithClasses(true)).WriteCSS(f, style); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to write CSS: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+}
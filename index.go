@@ -32,6 +32,8 @@ func (i *Index) Put(p *Post) {
 	i.tree.ReplaceOrInsert(i.postItem(p))
 }
 
+func (i *Index) Len() int { return i.tree.Len() }
+
 func (i *Index) Walk(fn func(string)) {
 	i.tree.Descend(func(it btree.Item) bool {
 		fn(it.(indexItem).ID)
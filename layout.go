@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+
+	"github.com/grokify/html-strip-tags-go"
+)
+
+// layoutFile is a single parsed file in the _layouts directory.
+type layoutFile struct {
+	name     string
+	extends  string
+	partials []string // partials is the name of each partial used in the file.
+	sum      []byte
+	trees    []*parse.Tree
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Layouts is the set of layout files in the _layouts directory. Each file is
+// parsed once when the Layouts are loaded, and is then re-used for every page
+// and post that is published.
+//
+// A layout can extend another layout by calling extends at the top of the
+// layout, for example {{extends "base"}}. Executing the layout will execute
+// the base layout, with any blocks defined in the base being overridden by
+// the templates defined in the layout.
+type Layouts struct {
+	mu    sync.Mutex
+	text  bool
+	funcs template.FuncMap
+	files map[string]*layoutFile
+	cache map[string]executor
+}
+
+// LoadLayouts parses every file in the _layouts directory. If text is true then
+// the layouts will be executed with text/template instead of html/template.
+func LoadLayouts(text bool) (*Layouts, error) {
+	l := &Layouts{
+		text:  text,
+		files: make(map[string]*layoutFile),
+		cache: make(map[string]executor),
+	}
+
+	l.funcs = template.FuncMap{
+		"extends": func(string) string { return "" },
+		"partial": l.partial,
+		"strip":   stripTags,
+	}
+
+	err := filepath.Walk(layoutsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(strings.TrimPrefix(path, layoutsDir+string(os.PathSeparator)))
+
+		f, err := l.parse(name, string(b))
+
+		if err != nil {
+			return err
+		}
+
+		l.files[name] = f
+		return nil
+	})
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// layoutExtends returns the name of the layout the given tree extends, if any.
+// This will only look at the first action in the tree.
+func layoutExtends(tree *parse.Tree) string {
+	if tree == nil || tree.Root == nil {
+		return ""
+	}
+
+	for _, n := range tree.Root.Nodes {
+		if text, ok := n.(*parse.TextNode); ok {
+			if len(bytes.TrimSpace(text.Text)) == 0 {
+				continue
+			}
+			return ""
+		}
+
+		action, ok := n.(*parse.ActionNode)
+
+		if !ok || len(action.Pipe.Cmds) != 1 {
+			return ""
+		}
+
+		args := action.Pipe.Cmds[0].Args
+
+		if len(args) != 2 {
+			return ""
+		}
+
+		if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != "extends" {
+			return ""
+		}
+
+		if s, ok := args[1].(*parse.StringNode); ok {
+			return s.Text
+		}
+		return ""
+	}
+	return ""
+}
+
+// layoutPartials appends the name of each partial called in the given node to
+// names. Only partials called with a string literal for the name are found.
+func layoutPartials(names []string, node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return names
+		}
+
+		for _, n := range n.Nodes {
+			names = layoutPartials(names, n)
+		}
+	case *parse.ActionNode:
+		names = layoutPartials(names, n.Pipe)
+	case *parse.IfNode:
+		names = layoutPartials(names, &n.BranchNode)
+	case *parse.RangeNode:
+		names = layoutPartials(names, &n.BranchNode)
+	case *parse.WithNode:
+		names = layoutPartials(names, &n.BranchNode)
+	case *parse.BranchNode:
+		names = layoutPartials(names, n.Pipe)
+		names = layoutPartials(names, n.List)
+		names = layoutPartials(names, n.ElseList)
+	case *parse.TemplateNode:
+		names = layoutPartials(names, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return names
+		}
+
+		for _, cmd := range n.Cmds {
+			names = layoutPartials(names, cmd)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "partial" {
+				if s, ok := n.Args[1].(*parse.StringNode); ok {
+					names = append(names, s.Text)
+				}
+			}
+		}
+
+		for _, arg := range n.Args {
+			names = layoutPartials(names, arg)
+		}
+	}
+	return names
+}
+
+func (l *Layouts) parse(name, text string) (*layoutFile, error) {
+	t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(l.funcs)).Parse(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(text))
+
+	f := &layoutFile{
+		name:    name,
+		extends: layoutExtends(t.Tree),
+		sum:     sum[:],
+	}
+
+	for _, t := range t.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		f.partials = layoutPartials(f.partials, t.Tree.Root)
+		f.trees = append(f.trees, t.Tree)
+	}
+	return f, nil
+}
+
+// chain returns the layout files for the given layout, starting with the given
+// layout followed by each layout it extends.
+func (l *Layouts) chain(name string) ([]*layoutFile, error) {
+	chain := make([]*layoutFile, 0)
+	seen := make(map[string]struct{})
+
+	for name != "" {
+		if _, ok := seen[name]; ok {
+			return nil, errors.New("layout " + name + " extends itself")
+		}
+
+		seen[name] = struct{}{}
+
+		f, ok := l.files[name]
+
+		if !ok {
+			return nil, fmt.Errorf("no such layout %q", name)
+		}
+
+		chain = append(chain, f)
+		name = f.extends
+	}
+	return chain, nil
+}
+
+// build creates the template for the given layout from the layout and the
+// layouts it extends. The trees of each layout are copied, so the escaping of
+// one template does not affect another.
+func (l *Layouts) build(name string) (executor, error) {
+	chain, err := l.chain(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	base := chain[len(chain)-1]
+
+	trees := make([]*parse.Tree, 0)
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, tree := range chain[i].trees {
+			// The top-level content of a layout that extends another is
+			// ignored, only the templates it defines are used.
+			if i != len(chain)-1 && tree.Name == chain[i].name {
+				continue
+			}
+			trees = append(trees, tree.Copy())
+		}
+	}
+
+	if l.text {
+		t := texttemplate.New(base.name).Funcs(texttemplate.FuncMap(l.funcs))
+
+		for _, tree := range trees {
+			if _, err := t.AddParseTree(tree.Name, tree); err != nil {
+				return nil, err
+			}
+		}
+		return t.Lookup(base.name), nil
+	}
+
+	t := template.New(base.name).Funcs(l.funcs)
+
+	for _, tree := range trees {
+		if _, err := t.AddParseTree(tree.Name, tree); err != nil {
+			return nil, err
+		}
+	}
+	return t.Lookup(base.name), nil
+}
+
+func (l *Layouts) lookup(name string) (executor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t, ok := l.cache[name]; ok {
+		return t, nil
+	}
+
+	t, err := l.build(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l.cache[name] = t
+	return t, nil
+}
+
+// stripTags strips the HTML tags from the given value. This takes any value, so
+// it can be given trusted HTML, such as the Description of a post, as well as
+// strings.
+func stripTags(v interface{}) string { return strip.StripTags(fmt.Sprint(v)) }
+
+// partial executes the given layout with the given data. The result is
+// returned as trusted HTML, since the partial will have been escaped when
+// executed.
+func (l *Layouts) partial(name string, data interface{}) (template.HTML, error) {
+	var buf bytes.Buffer
+
+	err := l.Execute(&buf, name, data)
+	return template.HTML(buf.String()), err
+}
+
+// Has reports whether a layout of the given name exists.
+func (l *Layouts) Has(name string) bool {
+	_, ok := l.files[name]
+	return ok
+}
+
+// Sum returns the checksum of the given layout, the layouts it extends, and
+// the partials used by each of them. Any partials used by the given text are
+// included too, this is used for pages whose content is itself a template. A
+// layout that does not exist still contributes its name to the checksum, so
+// creating the layout later on will change the checksum.
+func (l *Layouts) Sum(name, text string) []byte {
+	names := []string{name}
+
+	if text != "" {
+		t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(l.funcs)).Parse(text)
+
+		if err == nil {
+			for _, t := range t.Templates() {
+				if t.Tree == nil {
+					continue
+				}
+				names = layoutPartials(names, t.Tree.Root)
+			}
+		}
+	}
+
+	h := sha256.New()
+	seen := make(map[string]struct{})
+
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+
+		io.WriteString(h, name)
+
+		f, ok := l.files[name]
+
+		if !ok {
+			continue
+		}
+
+		h.Write(f.sum)
+
+		if f.extends != "" {
+			names = append(names, f.extends)
+		}
+		names = append(names, f.partials...)
+	}
+	return h.Sum(nil)
+}
+
+// Execute executes the given layout with the given data, and writes the output
+// to the given writer.
+func (l *Layouts) Execute(w io.Writer, name string, data interface{}) error {
+	t, err := l.lookup(name)
+
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
+
+// ExecuteText parses and executes the given text as a template. This does not
+// add the template to the Layouts, though the text will have access to the
+// same functions as the Layouts.
+func (l *Layouts) ExecuteText(w io.Writer, name, text string, data interface{}) error {
+	if l.text {
+		t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(l.funcs)).Parse(text)
+
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, data)
+	}
+
+	t, err := template.New(name).Funcs(l.funcs).Parse(text)
+
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 var LsCmd = &Command{
@@ -19,11 +20,28 @@ The -c flag can be given to only display posts in the given category. The -h
 flag can be given to hide pages, and display only posts. The -v flag can be
 given to detail hash information about each page or post. This will display
 whether or not the current item has been modified along with its current
-hash.`,
+hash. An item is modified if its content, front matter, layout, or the
+configuration it is rendered with has changed since it was last published.
+
+Posts that are not yet published will have their state displayed after their
+ID. A post will either be a draft, or scheduled for publishing at a later time
+as specified via the publishAt property in the front matter.`,
 	Run: lsCmd,
 }
 
-func printHashInfo(hash *Hash, argv0, id string, h Hasher) {
+// postState returns the state of the post if the post is not yet published,
+// otherwise an empty string is returned.
+func postState(p *Post, t time.Time) string {
+	switch state := p.State(t); state {
+	case "draft":
+		return state
+	case "scheduled":
+		return state + " " + p.PublishAt.String()
+	}
+	return ""
+}
+
+func printHashInfo(hash *Hash, argv0, id, state string, h Hasher) {
 	status := "unmodified "
 
 	b, _ := hash.Get(id)
@@ -37,6 +55,10 @@ func printHashInfo(hash *Hash, argv0, id string, h Hasher) {
 	if hex == "" {
 		hex = "000000000000"
 	}
+	if state != "" {
+		fmt.Println(status, id, hex, state)
+		return
+	}
 	fmt.Println(status, id, hex)
 }
 
@@ -72,7 +94,7 @@ func lsCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
-	hash, err := OpenHash()
+	hash, err := OpenHash(dataDir)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s %s: failed to open hash: %s\n", cmd.Argv0, args[0], err)
@@ -81,10 +103,33 @@ func lsCmd(cmd *Command, args []string) {
 
 	defer hash.Close()
 
+	var (
+		cfg     *Config
+		layouts *Layouts
+	)
+
+	if verbose {
+		cfg, err = OpenConfig()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+
+		defer cfg.Close()
+
+		layouts, err = LoadLayouts(cfg.Site.Template == "text")
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to load layouts: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+	}
+
 	if !hide {
 		for _, page := range pages {
 			if verbose {
-				printHashInfo(hash, cmd.Argv0+" "+args[0], page.ID, page)
+				printHashInfo(hash, cmd.Argv0+" "+args[0], page.ID, "", dependsOn(cfg, layouts, page, page.Layout, page.Source))
 				continue
 			}
 			fmt.Println(page.ID)
@@ -93,6 +138,8 @@ func lsCmd(cmd *Command, args []string) {
 
 	category = strings.ToLower(category)
 
+	now := time.Now()
+
 	for _, post := range posts {
 		print_ := true
 
@@ -101,8 +148,15 @@ func lsCmd(cmd *Command, args []string) {
 		}
 
 		if print_ {
+			state := postState(post, now)
+
 			if verbose {
-				printHashInfo(hash, cmd.Argv0+" "+args[0], post.ID, post)
+				printHashInfo(hash, cmd.Argv0+" "+args[0], post.ID, state, dependsOn(cfg, layouts, post, post.Layout, ""))
+				continue
+			}
+
+			if state != "" {
+				fmt.Println(post.ID, state)
 				continue
 			}
 			fmt.Println(post.ID)
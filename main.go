@@ -21,12 +21,16 @@ Usage:
 	cmds.Add("config", ConfigCmd)
 	cmds.Add("edit", EditCmd)
 	cmds.Add("flush", FlushCmd)
+	cmds.Add("highlight", HighlightCmd)
 	cmds.Add("init", InitCmd)
 	cmds.Add("ls", LsCmd)
 	cmds.Add("page", PageCmd)
 	cmds.Add("post", PostCmd)
 	cmds.Add("publish", PublishCmd)
+	cmds.Add("remote", RemoteCmd(cmds.Argv0))
 	cmds.Add("rm", RmCmd)
+	cmds.Add("rollback", RollbackCmd)
+	cmds.Add("serve", ServeCmd)
 	cmds.Add("theme", ThemeCmd(cmds.Argv0))
 	cmds.Add("version", VersionCmd)
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -23,10 +24,32 @@ var (
 	postLayout = []byte(`<html lang="en">
 	<head>
 		<title>{{.Post.Title}} - {{.Site.Title}}</title>
+		<meta name="description" content="{{strip .Post.Description}}">
 	</head>
 	<body>{{.Post.Body}}</body>
 </html>`)
 
+	footerPostLayout = []byte(`<html lang="en">
+	<head>
+		<title>{{.Post.Title}} - {{.Site.Title}}</title>
+	</head>
+	<body>
+		{{.Post.Body}}
+		{{partial "footer" .}}
+	</body>
+</html>`)
+
+	baseLayout = []byte(`<html lang="en">
+	<head>
+		<title>{{block "title" .}}{{.Site.Title}}{{end}}</title>
+	</head>
+	<body>{{block "body" .}}The base body.{{end}}</body>
+</html>`)
+
+	extendsPostLayout = []byte(`{{extends "base"}}
+{{define "title"}}{{.Post.Title}} - {{.Site.Title}}{{end}}
+{{define "body"}}<article>{{.Post.Body}}</article>{{end}}`)
+
 	indexLayout = []byte(`<html lang="en">
 	<head>
 		<title>{{.Site.Title}}</title>
@@ -50,6 +73,18 @@ var (
 		{{end}}
 	</body>
 </html>`)
+
+	tagIndexLayout = []byte(`<html lang="en">
+	<head>
+		<title>{{.Site.Title}} - {{.Tag.Name}}</title>
+	</head>
+	<body>
+		{{range $i, $p := .Posts}}
+			<strong>{{$p.Title}}</strong>
+			<div>{{$p.Description}}</div>
+		{{end}}
+	</body>
+</html>`)
 )
 
 func cleanup(tmpdir string) {
@@ -132,6 +167,178 @@ func checkPublishedRemote(remote string, paths ...string) checkFunc {
 	}
 }
 
+func checkNotPublishedRemote(remote string, paths ...string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		for _, path := range paths {
+			if _, err := os.Stat(filepath.Join(remote, path)); !os.IsNotExist(err) {
+				t.Fatalf("tests[%d](%s) - expected %q to not exist, got err=%v\n", id, cmd, path, err)
+			}
+		}
+	}
+}
+
+func checkAll(fns ...checkFunc) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		for _, fn := range fns {
+			fn(id, cmd, t)
+		}
+	}
+}
+
+// checkContains checks that the given file contains the given string, and does
+// not contain the given unexpected string, if any.
+func checkContains(path, expected, unexpected string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		b, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to read %q: %s\n", id, cmd, path, err)
+		}
+
+		if !strings.Contains(string(b), expected) {
+			t.Fatalf("tests[%d](%s) - expected %q to contain %q\n", id, cmd, path, expected)
+		}
+
+		if unexpected != "" && strings.Contains(string(b), unexpected) {
+			t.Fatalf("tests[%d](%s) - expected %q to not contain %q\n", id, cmd, path, unexpected)
+		}
+	}
+}
+
+// checkUnchanged returns a checkFunc that records the contents of the given
+// files, and a checkFunc that checks the files still have those contents.
+func checkUnchanged(paths ...string) (checkFunc, checkFunc) {
+	contents := make(map[string][]byte)
+
+	record := func(id int, cmd string, t *testing.T) {
+		for _, path := range paths {
+			b, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				t.Fatalf("tests[%d](%s) - failed to read %q: %s\n", id, cmd, path, err)
+			}
+			contents[path] = b
+		}
+	}
+
+	check := func(id int, cmd string, t *testing.T) {
+		for _, path := range paths {
+			b, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				t.Fatalf("tests[%d](%s) - failed to read %q: %s\n", id, cmd, path, err)
+			}
+
+			if !bytes.Equal(b, contents[path]) {
+				t.Fatalf("tests[%d](%s) - expected %q to be unchanged\n", id, cmd, path)
+			}
+		}
+	}
+	return record, check
+}
+
+// checkReleases checks that the given number of releases are on the remote,
+// and that the current release is the nth release, oldest first.
+func checkReleases(remote string, releases, n int) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		info, err := ioutil.ReadDir(filepath.Join(remote, "releases"))
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to read releases: %s\n", id, cmd, err)
+		}
+
+		if len(info) != releases {
+			t.Fatalf("tests[%d](%s) - unexpected number of releases, expected=%d, got=%d\n", id, cmd, releases, len(info))
+		}
+
+		target, err := os.Readlink(filepath.Join(remote, "current"))
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to read current release: %s\n", id, cmd, err)
+		}
+
+		if current := filepath.Base(target); current != info[n].Name() {
+			t.Fatalf("tests[%d](%s) - unexpected current release, expected=%q, got=%q\n", id, cmd, info[n].Name(), current)
+		}
+	}
+}
+
+// appendConfig appends the given TOML to the jrnl.toml file, as if the config
+// had been edited by hand.
+func appendConfig(config string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		f, err := os.OpenFile(configFile, os.O_APPEND|os.O_WRONLY, os.FileMode(0644))
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to open config: %s\n", id, cmd, err)
+		}
+
+		defer f.Close()
+
+		if _, err := f.WriteString(config); err != nil {
+			t.Fatalf("tests[%d](%s) - failed to append config: %s\n", id, cmd, err)
+		}
+	}
+}
+
+// movePost moves the source of a post, as if the post were recategorized by
+// hand.
+func movePost(src, dst string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		if err := os.MkdirAll(filepath.Dir(dst), os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Rename(src, dst); err != nil {
+			t.Fatalf("tests[%d](%s) - failed to move post: %s\n", id, cmd, err)
+		}
+	}
+}
+
+// editPost adds the given lines to the front matter of the given post, as if
+// the post had been edited.
+func editPost(path string, lines ...string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		b, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to read post: %s\n", id, cmd, err)
+		}
+
+		src := strings.Replace(string(b), "---\n", "---\n"+strings.Join(lines, "\n")+"\n", 1)
+
+		if err := ioutil.WriteFile(path, []byte(src), os.FileMode(0644)); err != nil {
+			t.Fatalf("tests[%d](%s) - failed to write post: %s\n", id, cmd, err)
+		}
+	}
+}
+
+// appendPost appends the given Markdown to the body of the given post.
+func appendPost(path, body string) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, os.FileMode(0644))
+
+		if err != nil {
+			t.Fatalf("tests[%d](%s) - failed to open post: %s\n", id, cmd, err)
+		}
+
+		defer f.Close()
+
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatalf("tests[%d](%s) - failed to write post: %s\n", id, cmd, err)
+		}
+	}
+}
+
+// writeLayout replaces the given layout.
+func writeLayout(name string, b []byte) checkFunc {
+	return func(id int, cmd string, t *testing.T) {
+		if err := ioutil.WriteFile(filepath.Join(layoutsDir, name), b, os.FileMode(0644)); err != nil {
+			t.Fatalf("tests[%d](%s) - failed to write layout: %s\n", id, cmd, err)
+		}
+	}
+}
+
 func splitargs(argv string) []string {
 	args := make([]string, 0)
 
@@ -186,9 +393,17 @@ func Test_Jrnl(t *testing.T) {
 
 	defer cleanup(dir)
 
+	staging := filepath.Join(dir, "staging")
+	live := filepath.Join(dir, "live")
+
 	now := time.Now()
 	date := strings.Replace(now.Format("2006-01-02"), "-", string(os.PathSeparator), -1)
 
+	recordDryRun, checkDryRun := checkUnchanged(
+		filepath.Join(dir, date, "first-post", "index.html"),
+		filepath.Join(dataDir, "hash"),
+	)
+
 	tests := []struct {
 		cmd       string
 		shouldErr bool
@@ -229,6 +444,11 @@ func Test_Jrnl(t *testing.T) {
 			false,
 			nil,
 		},
+		{
+			"jrnl config site.paginate 2",
+			false,
+			nil,
+		},
 		{
 			"jrnl publish",
 			false,
@@ -237,6 +457,9 @@ func Test_Jrnl(t *testing.T) {
 				filepath.Join(date, "first-post", "index.html"),
 				filepath.Join("programming", date, "go-101", "index.html"),
 				filepath.Join(date, "second-post", "index.html"),
+				"index.html",
+				filepath.Join("page", "2", "index.html"),
+				filepath.Join("programming", "index.html"),
 			),
 		},
 		{
@@ -253,6 +476,392 @@ func Test_Jrnl(t *testing.T) {
 				filepath.Join("programming", date, "go-101", "index.html"),
 			),
 		},
+		{
+			"jrnl publish -sync",
+			false,
+			checkPublishedRemote(
+				dir,
+				".jrnl-manifest",
+				filepath.Join(date, "first-post", "index.html"),
+				filepath.Join("programming", date, "go-101", "index.html"),
+			),
+		},
+		{
+			"jrnl config feed.atom atom.xml",
+			false,
+			nil,
+		},
+		{
+			"jrnl config feed.categories true",
+			false,
+			nil,
+		},
+		{
+			"jrnl config feed.atom ../atom.xml",
+			true,
+			nil,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkPublishedRemote(
+				dir,
+				"atom.xml",
+				filepath.Join("programming", "atom.xml"),
+			),
+		},
+		{
+			"jrnl post -l post 'Moving Post'",
+			false,
+			nil,
+		},
+		{
+			// The post is recategorized once published, leaving the old
+			// path stale on the remote.
+			"jrnl publish",
+			false,
+			checkAll(
+				checkPublishedRemote(dir, filepath.Join(date, "moving-post", "index.html")),
+				movePost(
+					filepath.Join(postsDir, "moving-post.md"),
+					filepath.Join(postsDir, "programming", "moving-post.md"),
+				),
+			),
+		},
+		{
+			"jrnl publish -stale",
+			false,
+			checkAll(
+				checkPublished(filepath.Join("programming", date, "moving-post", "index.html")),
+				checkPublishedRemote(dir, filepath.Join(date, "moving-post", "index.html")),
+				checkNotPublishedRemote(dir, filepath.Join("programming", date, "moving-post", "index.html")),
+			),
+		},
+		{
+			// The page layout is broken once published, so the about page
+			// fails to publish when pruning.
+			"jrnl publish",
+			false,
+			checkAll(
+				checkPublishedRemote(
+					dir,
+					filepath.Join(date, "moving-post", "index.html"),
+					filepath.Join("programming", date, "moving-post", "index.html"),
+				),
+				writeLayout("page", []byte(`{{.Page.Missing}}`)),
+			),
+		},
+		{
+			"jrnl publish -prune",
+			true,
+			checkAll(
+				checkPublishedRemote(
+					dir,
+					filepath.Join("programming", date, "moving-post", "index.html"),
+					filepath.Join("about", "index.html"),
+				),
+				checkNotPublishedRemote(dir, filepath.Join(date, "moving-post", "index.html")),
+				checkPublishedRemote(dataDir, "manifest"),
+				writeLayout("page", pageLayout),
+			),
+		},
+		{
+			"jrnl post -l post 'Draft Post'",
+			false,
+			editPost(filepath.Join(postsDir, "draft-post.md"), "draft: true"),
+		},
+		{
+			"jrnl post -l post 'Scheduled Post'",
+			false,
+			editPost(filepath.Join(postsDir, "scheduled-post.md"), "publishAt: 2999-01-01T00:00"),
+		},
+		{
+			"jrnl publish",
+			false,
+			checkNotPublishedRemote(
+				dir,
+				filepath.Join(date, "draft-post", "index.html"),
+				filepath.Join(date, "scheduled-post", "index.html"),
+			),
+		},
+		{
+			"jrnl publish -drafts",
+			false,
+			checkAll(
+				checkPublished(
+					filepath.Join(date, "draft-post", "index.html"),
+					filepath.Join(date, "scheduled-post", "index.html"),
+				),
+				checkNotPublishedRemote(
+					dir,
+					filepath.Join(date, "draft-post", "index.html"),
+					filepath.Join(date, "scheduled-post", "index.html"),
+				),
+			),
+		},
+		{
+			"jrnl post -l post 'Tagged Post'",
+			false,
+			checkAll(
+				editPost(filepath.Join(postsDir, "tagged-post.md"), "tags: [Go, Web]"),
+				writeLayout("tag-index", tagIndexLayout),
+			),
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkPublishedRemote(
+					dir,
+					filepath.Join("tags", "go", "index.html"),
+					filepath.Join("tags", "web", "index.html"),
+				),
+				checkContains(filepath.Join(dir, "tags", "go", "index.html"), "Tagged Post", "Go 101"),
+			),
+		},
+		{
+			"jrnl post -l post -c Summaries 'More Post'",
+			false,
+			appendPost(
+				filepath.Join(postsDir, "summaries", "more-post.md"),
+				"The introduction.\n\n<!--more-->\n\nThe rest of the post.\n",
+			),
+		},
+		{
+			"jrnl post -l post -c Summaries 'Summary Post'",
+			false,
+			checkAll(
+				editPost(filepath.Join(postsDir, "summaries", "summary-post.md"), "summary: The given summary."),
+				appendPost(filepath.Join(postsDir, "summaries", "summary-post.md"), "The first paragraph.\n"),
+			),
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, "summaries", "index.html"), "The introduction.", "The rest of the post."),
+				checkContains(filepath.Join(dir, "summaries", "index.html"), "The given summary.", "The first paragraph."),
+				checkContains(filepath.Join(dir, "summaries", date, "more-post", "index.html"), "The rest of the post.", ""),
+				checkContains(filepath.Join(dir, "summaries", date, "summary-post", "index.html"), `content="The given summary.`, "<p>The given summary."),
+			),
+		},
+		{
+			"jrnl config feed.rss rss.xml",
+			false,
+			nil,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, "atom.xml"), "<title>More Post</title>", "The rest of the post."),
+				checkContains(filepath.Join(dir, "atom.xml"), "<id>/summaries/"+filepath.ToSlash(date)+"/more-post</id>", ""),
+				checkContains(filepath.Join(dir, "atom.xml"), "<summary type=\"html\">The introduction.", ""),
+				checkContains(filepath.Join(dir, "rss.xml"), "<title>More Post</title>", "The rest of the post."),
+				checkContains(filepath.Join(dir, "rss.xml"), "<guid>/summaries/"+filepath.ToSlash(date)+"/more-post</guid>", ""),
+				checkContains(filepath.Join(dir, "summaries", "atom.xml"), "<title>Summary Post</title>", "Go 101"),
+			),
+		},
+		{
+			"jrnl config feed.content true",
+			false,
+			nil,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, "atom.xml"), "&lt;p&gt;The rest of the post.&lt;/p&gt;", ""),
+				checkContains(filepath.Join(dir, "rss.xml"), "<p>The rest of the post.</p>", ""),
+				recordDryRun,
+				appendPost(filepath.Join(postsDir, "first-post.md"), "An edit.\n"),
+			),
+		},
+		{
+			"jrnl publish -n",
+			false,
+			checkDryRun,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, date, "first-post", "index.html"), "An edit.", ""),
+				writeLayout("footer", []byte(`<footer>First footer</footer>`)),
+				writeLayout("post", footerPostLayout),
+			),
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, date, "first-post", "index.html"), "First footer", ""),
+				checkContains(filepath.Join(dir, "programming", date, "go-101", "index.html"), "First footer", ""),
+				writeLayout("footer", []byte(`<footer>Second footer</footer>`)),
+			),
+		},
+		{
+			// Only the partial changed, so the posts are re-rendered, and
+			// copied again.
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, date, "first-post", "index.html"), "Second footer", "First footer"),
+				checkContains(filepath.Join(dir, "programming", date, "go-101", "index.html"), "Second footer", "First footer"),
+			),
+		},
+		{
+			"jrnl config site.title 'Changed Title'",
+			false,
+			nil,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(filepath.Join(dir, date, "first-post", "index.html"), "First Post - Changed Title", ""),
+				writeLayout("base", baseLayout),
+				writeLayout("post", extendsPostLayout),
+			),
+		},
+		{
+			"jrnl post -l post 'Escaped <script>alert(1)</script>'",
+			false,
+			appendPost(filepath.Join(postsDir, "escaped-script-alert-1-script.md"), "The escaped post.\n"),
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkContains(
+					filepath.Join(dir, date, "escaped-script-alert-1-script", "index.html"),
+					"<title>Escaped &lt;script&gt;alert(1)&lt;/script&gt; - Changed Title</title>",
+					"<script>",
+				),
+				checkContains(
+					filepath.Join(dir, date, "escaped-script-alert-1-script", "index.html"),
+					"<article><p>The escaped post.</p>",
+					"The base body.",
+				),
+				checkContains(filepath.Join(dir, date, "first-post", "index.html"), "<article>", "Second footer"),
+			),
+		},
+		{
+			"jrnl config site.link https://example.com",
+			false,
+			nil,
+		},
+		{
+			"jrnl config site.robots true",
+			false,
+			nil,
+		},
+		{
+			"jrnl publish",
+			false,
+			checkAll(
+				checkPublishedRemote(dir, "sitemap.xml", "robots.txt"),
+				checkContains(
+					filepath.Join(dir, "sitemap.xml"),
+					"<loc>https://example.com/"+filepath.ToSlash(date)+"/first-post</loc>\n\t\t<lastmod>"+now.Format("2006-01-02")+"</lastmod>",
+					"",
+				),
+				checkContains(filepath.Join(dir, "sitemap.xml"), "<loc>https://example.com/programming/"+filepath.ToSlash(date)+"/go-101</loc>", ""),
+				checkContains(filepath.Join(dir, "robots.txt"), "Sitemap: https://example.com/sitemap.xml", ""),
+			),
+		},
+		{
+			"jrnl remote add -l https://staging.example.com staging file://" + filepath.ToSlash(staging),
+			false,
+			nil,
+		},
+		{
+			"jrnl remote add staging file://" + filepath.ToSlash(staging),
+			true,
+			nil,
+		},
+		{
+			"jrnl remote add production ftp://example.com/blog",
+			true,
+			nil,
+		},
+		{
+			"jrnl publish -to staging",
+			false,
+			checkAll(
+				checkPublishedRemote(
+					staging,
+					"index.html",
+					"atom.xml",
+					filepath.Join(date, "first-post", "index.html"),
+				),
+				checkContains(filepath.Join(staging, "atom.xml"), "https://staging.example.com", ""),
+				checkPublishedRemote(filepath.Join(dataDir, "remote", "staging"), "hash", "manifest"),
+			),
+		},
+		{
+			"jrnl publish -to production",
+			true,
+			nil,
+		},
+		{
+			"jrnl remote rm staging",
+			false,
+			checkAll(
+				checkNotPublishedRemote(dataDir, filepath.Join("remote", "staging")),
+				appendConfig("\n[remote.staging]\nurl = \""+filepath.ToSlash(staging)+"\"\n"),
+			),
+		},
+		{
+			"jrnl publish -to staging",
+			false,
+			checkPublishedRemote(filepath.Join(dataDir, "remote", "staging"), "hash", "manifest"),
+		},
+		{
+			"jrnl remote rm staging",
+			false,
+			checkNotPublishedRemote(dataDir, filepath.Join("remote", "staging")),
+		},
+		{
+			"jrnl remote add -r 2 live file://" + filepath.ToSlash(live),
+			false,
+			nil,
+		},
+		{
+			"jrnl publish -to live",
+			false,
+			checkAll(
+				checkPublishedRemote(
+					live,
+					filepath.Join("current", "index.html"),
+					filepath.Join("current", date, "tagged-post", "index.html"),
+				),
+				checkReleases(live, 1, 0),
+			),
+		},
+		{
+			"jrnl publish -to live",
+			false,
+			checkReleases(live, 2, 1),
+		},
+		{
+			"jrnl publish -to live",
+			false,
+			checkReleases(live, 2, 1),
+		},
+		{
+			"jrnl rollback -to live",
+			false,
+			checkAll(
+				checkReleases(live, 2, 0),
+				checkNotPublishedRemote(filepath.Join(dataDir, "remote", "live"), "hash"),
+			),
+		},
+		{
+			"jrnl rm -to live tagged-post",
+			false,
+			checkPublishedRemote(live, filepath.Join("current", date, "tagged-post", "index.html")),
+		},
 	}
 
 	os.Setenv("EDITOR", "true")
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Manifest is the set of files in the _site directory that have been copied to
+// the remote. This is stored in the manifest file next to the hash of the
+// remote.
+type Manifest struct {
+	f   *os.File
+	set map[string]struct{}
+}
+
+// OpenManifest opens the manifest file in the given directory. If the manifest
+// file does not exist yet then the manifest is seeded with the files currently
+// in the _site directory, since these will have most likely been copied to the
+// remote.
+func OpenManifest(dir string) (*Manifest, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "manifest"), os.O_CREATE|os.O_RDWR, os.FileMode(0644))
+
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := decodeManifest(f)
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Manifest{
+		f:   f,
+		set: set,
+	}, nil
+}
+
+// ReadManifest reads the manifest file in the given directory into a Manifest
+// that is held in memory only, and cannot be saved. Neither the directory nor
+// the file are created if they do not exist.
+func ReadManifest(dir string) (*Manifest, error) {
+	f, err := os.Open(filepath.Join(dir, "manifest"))
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		set, err := decodeManifest(strings.NewReader(""))
+
+		if err != nil {
+			return nil, err
+		}
+		return &Manifest{set: set}, nil
+	}
+
+	defer f.Close()
+
+	set, err := decodeManifest(f)
+
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{set: set}, nil
+}
+
+// decodeManifest decodes the manifest from the given reader, seeding it with
+// the files in the _site directory if the reader is empty.
+func decodeManifest(r io.Reader) (map[string]struct{}, error) {
+	set := make(map[string]struct{})
+
+	if err := gob.NewDecoder(r).Decode(&set); err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+
+		err := filepath.Walk(siteDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() {
+				set[path] = struct{}{}
+			}
+			return nil
+		})
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func (m *Manifest) Put(path string) { m.set[path] = struct{}{} }
+
+func (m *Manifest) Delete(path string) { delete(m.set, path) }
+
+// Stale returns the sorted paths in the manifest that are not in the given
+// list of outputs.
+func (m *Manifest) Stale(outputs []string) []string {
+	set := make(map[string]struct{}, len(outputs))
+
+	for _, path := range outputs {
+		set[path] = struct{}{}
+	}
+
+	stale := make([]string, 0)
+
+	for path := range m.set {
+		if _, ok := set[path]; !ok {
+			stale = append(stale, path)
+		}
+	}
+
+	sort.Strings(stale)
+	return stale
+}
+
+func (m *Manifest) Save() error {
+	if err := m.f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := m.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return gob.NewEncoder(m.f).Encode(m.set)
+}
+
+func (m *Manifest) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// newMarkdown returns the Markdown converter used for rendering pages, posts,
+// and the previews of posts in indexes and feeds. The extensions and options
+// used are configured via the markdown section of the config.
+func newMarkdown(cfg *Config) goldmark.Markdown {
+	exts := []goldmark.Extender{
+		extension.Table,
+		extension.Strikethrough,
+		extension.TaskList,
+	}
+
+	if cfg.Markdown.Linkify {
+		exts = append(exts, extension.Linkify)
+	}
+
+	if cfg.Markdown.Footnotes {
+		exts = append(exts, extension.Footnote)
+	}
+
+	if cfg.Markdown.DefinitionLists {
+		exts = append(exts, extension.DefinitionList)
+	}
+
+	if cfg.Markdown.Typographer {
+		exts = append(exts, extension.Typographer)
+	}
+
+	if cfg.Markdown.HighlightStyle != "" {
+		exts = append(exts, highlighting.NewHighlighting(
+			highlighting.WithStyle(cfg.Markdown.HighlightStyle),
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(cfg.Markdown.HighlightClasses),
+			),
+		))
+	}
+
+	parserOpts := make([]parser.Option, 0)
+
+	switch cfg.Markdown.HeadingIDs {
+	case "none":
+		// Headings are not given any IDs.
+	case "attribute":
+		parserOpts = append(parserOpts, parser.WithAttribute())
+	default:
+		parserOpts = append(parserOpts, parser.WithAutoHeadingID())
+	}
+
+	md := goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(parserOpts...),
+	)
+
+	if cfg.Markdown.HardWraps {
+		md.Renderer().AddOptions(html.WithHardWraps())
+	}
+
+	if cfg.Markdown.Unsafe {
+		md.Renderer().AddOptions(html.WithUnsafe())
+	}
+	return md
+}
@@ -6,20 +6,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"text/template"
-
-	"github.com/grokify/html-strip-tags-go"
 
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
 
 	"gopkg.in/yaml.v3"
 )
@@ -33,17 +28,21 @@ type Page struct {
 	ID         string
 	Title      string
 	Layout     string
-	Body       string
 	SourcePath string
 	SitePath   string
+
+	// Source is the Markdown of the page as written.
+	Source string
+
+	// Body is the rendered HTML of the page, this is only set when the page
+	// is published.
+	Body template.HTML
 }
 
 var (
 	reslug = regexp.MustCompile("[^a-zA-Z0-9]")
 	redup  = regexp.MustCompile("-{2,}")
 
-	funcs template.FuncMap
-
 	PageCmd = &Command{
 		Usage: "page <title>",
 		Short: "create a new journal page",
@@ -56,54 +55,15 @@ be pre-populated in the front matter.`,
 	}
 )
 
-func init() {
-	funcs = template.FuncMap{
-		"partial": partial,
-		"strip":   strip.StripTags,
-	}
-}
-
-func partial(path string, data interface{}) (string, error) {
-	b, err := ioutil.ReadFile(filepath.Join(layoutsDir, path))
-
-	if err != nil {
-		return "", err
-	}
-
-	t, err := template.New(path).Funcs(funcs).Parse(string(b))
-
-	if err != nil {
-		return "", err
-	}
-
+// render renders the given Markdown to HTML. The returned HTML is trusted, and
+// will not be escaped when placed in a layout.
+func render(md goldmark.Markdown, s string) (template.HTML, error) {
 	var buf bytes.Buffer
 
-	err = t.Execute(&buf, data)
-	return buf.String(), err
-}
-
-func executeTemplate(w io.Writer, name, text string, data interface{}) error {
-	t, err := template.New(name).Funcs(funcs).Parse(text)
-
-	if err != nil {
-		return err
-	}
-	return t.Execute(w, data)
-}
-
-func render(s string) (string, error) {
-	var buf bytes.Buffer
-
-	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
-	)
-	md.Renderer().AddOptions(html.WithUnsafe())
-
 	if err := md.Convert([]byte(s), &buf); err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	return template.HTML(buf.String()), nil
 }
 
 func resolvePage(path string) (*Page, error) {
@@ -231,36 +191,11 @@ func WalkPages(fn func(*Page) error) error {
 	})
 }
 
-func (p *Page) readLayout() (string, error) {
-	if p.Layout == "" {
-		return "", errors.New("layout not set")
-	}
-
-	b, err := ioutil.ReadFile(filepath.Join(layoutsDir, p.Layout))
-
-	if err != nil {
-		return "", err
-	}
-	return string(b), err
-}
-
-func (p *Page) siteFile() (*os.File, error) {
-	if err := os.MkdirAll(filepath.Dir(p.SitePath), os.FileMode(0755)); err != nil {
-		return nil, err
-	}
-
-	f, err := os.OpenFile(p.SitePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
-
-	if err != nil {
-		return nil, err
-	}
-	return f, nil
-}
-
 func (p *Page) Hash() []byte {
 	sha256 := sha256.New()
 	sha256.Write([]byte(p.Title))
-	sha256.Write([]byte(p.Body))
+	sha256.Write([]byte(p.Layout))
+	sha256.Write([]byte(p.Source))
 	return sha256.Sum(nil)
 }
 
@@ -295,21 +230,19 @@ func (p *Page) Load() error {
 	p.Title = fm.Title
 	p.Layout = fm.Layout
 	p.SitePath = filepath.Join(siteDir, filepath.Base(p.ID), "index.html")
-	p.Body = string(b)
+	p.Source = string(b)
 	return nil
 }
 
 func (p *Page) Publish(s Site) error {
-	renderedBody, err := render(p.Body)
+	renderedBody, err := render(s.markdown, p.Source)
 
 	if err != nil {
 		return err
 	}
 
-	layout, err := p.readLayout()
-
-	if err != nil {
-		return err
+	if p.Layout == "" {
+		return errors.New("layout not set")
 	}
 
 	var buf bytes.Buffer
@@ -318,20 +251,12 @@ func (p *Page) Publish(s Site) error {
 		Site Site
 	}{Site: s}
 
-	if err := executeTemplate(&buf, p.ID, renderedBody, data0); err != nil {
-		return err
-	}
-
-	f, err := p.siteFile()
-
-	if err != nil {
+	if err := s.layouts.ExecuteText(&buf, p.ID, string(renderedBody), data0); err != nil {
 		return err
 	}
 
-	defer f.Close()
-
 	p1 := *p
-	p1.Body = buf.String()
+	p1.Body = template.HTML(buf.String())
 
 	data := struct {
 		Site Site
@@ -340,7 +265,13 @@ func (p *Page) Publish(s Site) error {
 		Site: s,
 		Page: &p1,
 	}
-	return executeTemplate(f, p.ID, layout, data)
+
+	buf.Reset()
+
+	if err := s.layouts.Execute(&buf, p.Layout, data); err != nil {
+		return err
+	}
+	return s.write(p.SitePath, buf.Bytes())
 }
 
 func (p *Page) Touch() error {
@@ -361,7 +292,7 @@ func (p *Page) Touch() error {
 		return err
 	}
 
-	_, err = f.Write([]byte(p.Body))
+	_, err = f.Write([]byte(p.Source))
 	return err
 }
 
@@ -403,7 +334,7 @@ func (p *Page) Remove() error {
 		return err
 	}
 
-	hash, err := OpenHash()
+	hash, err := OpenHash(dataDir)
 
 	if err != nil {
 		return err
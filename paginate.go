@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// Paginator is a single page of posts in an index.
+type Paginator struct {
+	Page     int     // Page is the number of the current page, starting from 1.
+	Pages    int     // Pages is the total number of pages in the index.
+	Posts    []*Post // Posts is the slice of posts on the current page.
+	PrevHref string  // PrevHref is the href to the previous page, if any.
+	NextHref string  // NextHref is the href to the next page, if any.
+
+	href string
+}
+
+func pageHref(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	return path.Join(base, "page", strconv.Itoa(n))
+}
+
+// paginate splits the given posts into pages of n posts each. The given base
+// is the href of the first page, subsequent pages will be beneath base in the
+// page directory, for example /page/2. If n is 0 or less, then a single page
+// containing every post is returned.
+func paginate(posts []*Post, n int, base string) []*Paginator {
+	if n <= 0 || len(posts) == 0 {
+		n = len(posts)
+	}
+
+	pages := 1
+
+	if n > 0 {
+		pages = (len(posts) + n - 1) / n
+	}
+
+	pp := make([]*Paginator, 0, pages)
+
+	for i := 1; i <= pages; i++ {
+		start := (i - 1) * n
+		end := start + n
+
+		if end > len(posts) {
+			end = len(posts)
+		}
+
+		p := &Paginator{
+			Page:  i,
+			Pages: pages,
+			Posts: posts[start:end],
+			href:  pageHref(base, i),
+		}
+
+		if i > 1 {
+			p.PrevHref = pageHref(base, i-1)
+		}
+
+		if i < pages {
+			p.NextHref = pageHref(base, i+1)
+		}
+		pp = append(pp, p)
+	}
+	return pp
+}
+
+func (p *Paginator) HasPrev() bool { return p.PrevHref != "" }
+
+func (p *Paginator) HasNext() bool { return p.NextHref != "" }
+
+func (p *Paginator) Href() string { return p.href }
+
+// sitePath returns the path to the index.html file for the page in the _site
+// directory.
+func (p *Paginator) sitePath() string {
+	return filepath.Join(siteDir, filepath.FromSlash(p.href), "index.html")
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
@@ -20,6 +22,10 @@ type postFrontMatter struct {
 
 	CreatedAt postTime `yaml:"createdAt"`
 	UpdatedAt postTime `yaml:"updatedAt"`
+	Draft     bool     `yaml:"draft,omitempty"`
+	PublishAt postTime `yaml:"publishAt,omitempty"`
+	Tags      []string `yaml:"tags,omitempty"`
+	Summary   string   `yaml:"summary,omitempty"`
 }
 
 type postTime struct {
@@ -32,14 +38,39 @@ type Category struct {
 	Categories []*Category
 }
 
+type Tag struct {
+	ID   string
+	Name string
+}
+
 type Post struct {
 	*Page
 
-	Category    *Category
-	Index       bool
-	Description string
-	CreatedAt   postTime
-	UpdatedAt   postTime
+	Category *Category
+	Tags     []*Tag
+	Index    bool
+
+	// Summary is the rendered summary of the post. This is either the summary
+	// given in the front matter, everything before the <!--more--> marker, or
+	// the first paragraph of the post, in that order.
+	Summary template.HTML
+
+	// Truncated denotes whether the Summary is only part of the post.
+	Truncated bool
+
+	// Description is the same as the Summary.
+	Description template.HTML
+
+	CreatedAt postTime
+	UpdatedAt postTime
+	Draft     bool
+	PublishAt postTime
+
+	summary string
+
+	// summarySource is the Markdown of the summary, the rendered HTML of
+	// which is Summary.
+	summarySource string
 }
 
 var (
@@ -47,6 +78,8 @@ var (
 
 	redash = regexp.MustCompile("-")
 
+	moreSep = "<!--more-->"
+
 	PostCmd = &Command{
 		Usage: "post <title>",
 		Short: "create a new journal post",
@@ -87,6 +120,62 @@ func resolveCategory(path string) (*Category, error) {
 	}, nil
 }
 
+// resolveTags returns the tags for the given names. Names that resolve to the
+// same tag ID are only returned once.
+func resolveTags(names []string) []*Tag {
+	tags := make([]*Tag, 0, len(names))
+	set := make(map[string]struct{})
+
+	for _, name := range names {
+		id := slug(name)
+
+		if id == "" {
+			continue
+		}
+
+		if _, ok := set[id]; ok {
+			continue
+		}
+
+		set[id] = struct{}{}
+
+		tags = append(tags, &Tag{
+			ID:   id,
+			Name: strings.TrimSpace(name),
+		})
+	}
+	return tags
+}
+
+// summarize returns the summary for a post with the given body, and whether
+// the summary is only part of the post. If the given summary is empty then
+// everything before the <!--more--> marker in the body is used, otherwise the
+// first paragraph of the body.
+func summarize(summary, body string) (string, bool) {
+	if summary != "" {
+		return summary, strings.TrimSpace(body) != ""
+	}
+
+	if i := strings.Index(body, moreSep); i >= 0 {
+		return body[:i], strings.TrimSpace(body[i+len(moreSep):]) != ""
+	}
+
+	if len(body) <= 4 {
+		return "", false
+	}
+
+	i := strings.Index(body, "\n\n")
+
+	if i < 0 {
+		i = strings.Index(body, "\n")
+	}
+
+	if i < 0 {
+		return body, false
+	}
+	return body[:i], strings.TrimSpace(body[i:]) != ""
+}
+
 func resolvePost(path string) (*Post, error) {
 	p := &Post{
 		Page: &Page{
@@ -231,7 +320,7 @@ func (t *postTime) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
-	t.Time, err = time.Parse(iso8601, s)
+	t.Time, err = time.ParseInLocation(iso8601, s, time.Local)
 	return err
 }
 
@@ -239,8 +328,48 @@ func (t *postTime) String() string {
 	return t.Format(iso8601)
 }
 
+// Hash returns the hash of the post's content, and the front matter of the
+// post that affects how it is rendered.
+func (p *Post) Hash() []byte {
+	sha256 := sha256.New()
+	sha256.Write(p.Page.Hash())
+	sha256.Write([]byte(p.Category.ID))
+
+	for _, t := range p.Tags {
+		sha256.Write([]byte(t.Name))
+	}
+
+	sha256.Write([]byte(p.CreatedAt.String()))
+	sha256.Write([]byte(p.UpdatedAt.String()))
+	sha256.Write([]byte(p.summarySource))
+	return sha256.Sum(nil)
+}
+
 func (p *Post) HasCategory() bool { return p.Category.ID != "" }
 
+// Published reports whether the post should be published at the given time.
+// A post will not be published if it is a draft, or if its publishAt time has
+// not yet passed.
+func (p *Post) Published(t time.Time) bool {
+	if p.Draft {
+		return false
+	}
+	return !p.PublishAt.After(t)
+}
+
+// State returns the state of the post at the given time, this will either be
+// "draft", "scheduled", or "published".
+func (p *Post) State(t time.Time) string {
+	if p.Draft {
+		return "draft"
+	}
+
+	if p.PublishAt.After(t) {
+		return "scheduled"
+	}
+	return "published"
+}
+
 func (p *Post) Load() error {
 	f, err := os.Open(p.SourcePath)
 
@@ -262,14 +391,7 @@ func (p *Post) Load() error {
 		return err
 	}
 
-	if len(b) > 4 {
-		i := strings.Index(string(b), "\n\n")
-
-		if i < 0 {
-			i = strings.Index(string(b), "\n")
-		}
-		p.Description = string(b[:i])
-	}
+	summary, truncated := summarize(fm.Summary, string(b))
 
 	trimmed := strings.Replace(p.SourcePath, postsDir+string(os.PathSeparator), "", 1)
 	parts := strings.Split(trimmed, string(os.PathSeparator))
@@ -296,45 +418,42 @@ func (p *Post) Load() error {
 		strings.Replace(p.ID, p.Category.ID, "", 1),
 		"index.html",
 	)
-	p.Body = string(b)
+	p.Source = string(b)
 	p.CreatedAt = fm.CreatedAt
 	p.UpdatedAt = fm.UpdatedAt
+	p.Draft = fm.Draft
+	p.PublishAt = fm.PublishAt
+	p.Tags = resolveTags(fm.Tags)
+	p.Truncated = truncated
+	p.summarySource = summary
+	p.summary = fm.Summary
 	return nil
 }
 
 func (p *Post) Publish(s Site) error {
-	renderedDesc, err := render(p.Description)
-
-	if err != nil {
-		return err
-	}
-
-	renderedBody, err := render(p.Body)
+	renderedSummary, err := render(s.markdown, p.summarySource)
 
 	if err != nil {
 		return err
 	}
 
-	layout, err := p.readLayout()
+	renderedBody, err := render(s.markdown, p.Source)
 
 	if err != nil {
 		return err
 	}
 
-	f, err := p.siteFile()
-
-	if err != nil {
-		return err
+	if p.Layout == "" {
+		return errors.New("layout not set")
 	}
 
-	defer f.Close()
-
 	page := *p.Page
 	page.Body = renderedBody
 
 	p1 := *p
 	p1.Page = &page
-	p1.Description = renderedDesc
+	p1.Summary = renderedSummary
+	p1.Description = renderedSummary
 
 	data := struct {
 		Site Site
@@ -343,7 +462,13 @@ func (p *Post) Publish(s Site) error {
 		Site: s,
 		Post: &p1,
 	}
-	return executeTemplate(f, p.ID, layout, data)
+
+	var buf bytes.Buffer
+
+	if err := s.layouts.Execute(&buf, p.Layout, data); err != nil {
+		return err
+	}
+	return s.write(p.SitePath, buf.Bytes())
 }
 
 func (p *Post) Remove() error {
@@ -405,13 +530,20 @@ func (p *Post) Touch() error {
 		UpdatedAt: postTime{
 			Time: time.Now(),
 		},
+		Draft:     p.Draft,
+		PublishAt: p.PublishAt,
+		Summary:   p.summary,
+	}
+
+	for _, t := range p.Tags {
+		fm.Tags = append(fm.Tags, t.Name)
 	}
 
 	if err := marshalFrontMatter(&fm, f); err != nil {
 		return err
 	}
 
-	if _, err := f.Write([]byte(p.Body)); err != nil {
+	if _, err := f.Write([]byte(p.Source)); err != nil {
 		return err
 	}
 	return nil
@@ -419,6 +551,8 @@ func (p *Post) Touch() error {
 
 func (c Category) Href() string { return "/" + c.ID }
 
+func (t Tag) Href() string { return "/tags/" + t.ID }
+
 func postCmd(cmd *Command, args []string) {
 	var (
 		category string
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_PostTime(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+
+	// A zone far from UTC, so a time parsed as UTC would be hours out.
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+
+	var fm postFrontMatter
+
+	if err := yaml.Unmarshal([]byte("publishAt: 2006-01-02T15:04\n"), &fm); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2006, time.January, 2, 15, 4, 0, 0, time.Local)
+
+	if !fm.PublishAt.Equal(expected) {
+		t.Fatalf("unexpected publishAt, expected=%s, got=%s\n", expected, fm.PublishAt.Time)
+	}
+
+	b, err := yaml.Marshal(fm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fm2 postFrontMatter
+
+	if err := yaml.Unmarshal(b, &fm2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fm2.PublishAt.Equal(fm.PublishAt.Time) {
+		t.Fatalf("unexpected publishAt after round trip, expected=%s, got=%s\n", fm.PublishAt.Time, fm2.PublishAt.Time)
+	}
+}
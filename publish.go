@@ -5,20 +5,21 @@ import (
 	"crypto/sha256"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/gorilla/feeds"
 
 	"github.com/grokify/html-strip-tags-go"
 
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
 )
 
 type publishError struct {
@@ -27,6 +28,25 @@ type publishError struct {
 	err  error
 }
 
+// publishOptions configures how the journal is published.
+type publishOptions struct {
+	atom   string // atom is the file to write the Atom feed to, if any.
+	rss    string // rss is the file to write the RSS feed to, if any.
+	drafts bool   // drafts will publish draft and scheduled posts too.
+	dir    string // dir is the directory to write the files to, if not the current one.
+}
+
+// publishResult is the result of publishing the journal.
+type publishResult struct {
+	// paths is the list of files that were modified, and should be copied to
+	// the remote.
+	paths []string
+
+	// outputs is the list of every file produced by the journal, whether
+	// modified or not.
+	outputs []string
+}
+
 type Directory string
 
 type Site struct {
@@ -34,13 +54,46 @@ type Site struct {
 	Description string
 	Link        string
 	Categories  []*Category
+	Tags        []*Tag
 	Pages       []*Page
+	layouts     *Layouts
+	markdown    goldmark.Markdown
+	dir         string
 	Author      struct {
 		Name  string
 		Email string
 	}
 }
 
+// create creates the file at the given path for writing, along with any of
+// the parent directories. The path is created beneath the directory the site
+// is being written to.
+func (s Site) create(path string) (*os.File, error) {
+	path = filepath.Join(s.dir, path)
+
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+}
+
+// write writes the given contents to the given path in the site. Pages and
+// posts are rendered in full before being written, so one that fails to render
+// leaves what was previously published in place.
+func (s Site) write(path string, b []byte) error {
+	f, err := s.create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 var PublishCmd = &Command{
 	Usage: "publish [options]",
 	Short: "publish the journal to the remote",
@@ -49,12 +102,65 @@ directory. The contents of the _site directory will then be copied to the
 configured remote.
 
 The -a and -r flags can be given to generate an Atom and RSS feed respectively
-to the specified paths.
+to the specified paths. These override the feed.atom and feed.rss properties
+for the site feed. Feeds for each category and tag are generated as configured
+in the [feed] table of jrnl.toml, see "jrnl help config".
+
+If site.link is set then a sitemap.xml file will be generated in the _site
+directory listing every published page and post. If site.robots is true then
+a robots.txt file referencing the sitemap will be generated too.
 
 The -d flag will not copy the contents of the _site directory to the configured
 remote.
 
-The -v flag will print out which paths are being copied to the remote.`,
+The -drafts flag will publish draft posts, and posts scheduled for a later time
+along with everything else. This implies the -d flag, so none of the draft or
+scheduled posts will be copied to the remote.
+
+The -prune flag will remove any files from the remote that were previously
+copied there, but are no longer published, such as posts that have been moved
+to a different category. The files copied to the remote are recorded in the
+_data/manifest file. The -stale flag will list the files that would be removed
+by -prune without removing them, this implies -d.
+
+If site.releases is set then the journal is deployed atomically. Every file
+is deployed to a new release directory on the remote, and the current symlink
+on the remote is then pointed to the new release. Unmodified files are hard
+linked from the previous release. If any file fails to be copied then the new
+release is removed, leaving the current release in place. Stale files are never
+carried over into a new release, so -prune is not needed.
+
+The -n flag will perform a dry run of the publish. Each page and post will be
+generated in a temporary directory, and the files that would be copied to the
+remote will be printed. If -prune is given too, then the files that would be
+removed from the remote are printed as well. Nothing in the _data or _site
+directories is modified, and the remote is not touched.
+
+The -j flag sets the number of files that are copied to the remote at once, by
+default this is 4. Any files that fail to be copied are reported once every
+other file has been copied.
+
+The -sync flag will compare the _site directory to the .jrnl-manifest file on
+the remote, instead of relying on the _data/hash file. The .jrnl-manifest file
+records the size and SHA256 checksum of every file copied to the remote. Every
+page and post is generated, and only the files that differ from the manifest
+on the remote are copied. Files in the manifest on the remote that are no
+longer published are removed from the remote. Since the manifest is stored on
+the remote itself, this allows the journal to be published from more than one
+machine. If the manifest on the remote is changed by another publish while
+syncing, then the publish is aborted without overwriting the manifest.
+Publishing to the same remote from two machines at the same time is not
+supported. This cannot be used if site.releases is set.
+
+The -to flag will publish to the given named remote instead of site.remote.
+The link and releases of the named remote are used instead of site.link and
+site.releases, if set, so any absolute URLs in the feeds and sitemap point to
+the remote being published to. Each named remote has its own hash and manifest
+in the _data/remote directory, so publishing to one remote does not affect
+what is copied to another.
+
+The -v flag will print out which paths are being copied to the remote, along
+with the progress of the copy.`,
 	Run: publishCmd,
 }
 
@@ -71,23 +177,39 @@ func previewPost(id string, md goldmark.Markdown, buf *bytes.Buffer) (*Post, boo
 		return nil, false, nil
 	}
 
-	if err := md.Convert([]byte(p.Description), buf); err != nil {
+	if err := md.Convert([]byte(p.summarySource), buf); err != nil {
 		return nil, false, err
 	}
 
-	p.Description = buf.String()
+	p.Summary = template.HTML(buf.String())
+	p.Description = p.Summary
 	return p, true, nil
 }
 
-func publishCategoryIndex(s Site, layout []byte, categoryidx map[string]*Index) ([]string, error) {
+// publishIndex writes the index.html file for each of the given pages of an
+// index. The data passed to the layout for each page is returned by fn.
+func publishIndex(s Site, layout string, pages []*Paginator, fn func(*Paginator) interface{}) ([]string, error) {
+	paths := make([]string, 0, len(pages))
+
+	for _, pg := range pages {
+		var buf bytes.Buffer
+
+		if err := s.layouts.Execute(&buf, layout, fn(pg)); err != nil {
+			return nil, err
+		}
+
+		if err := s.write(pg.sitePath(), buf.Bytes()); err != nil {
+			return nil, err
+		}
+		paths = append(paths, pg.sitePath())
+	}
+	return paths, nil
+}
+
+func publishCategoryIndex(s Site, categoryidx map[string]*Index, perPage int) ([]string, error) {
 	posts := make([]*Post, 0)
 	paths := make([]string, 0, len(categoryidx))
 
-	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
-	)
-
 	var buf bytes.Buffer
 
 	for id, index := range categoryidx {
@@ -106,7 +228,7 @@ func publishCategoryIndex(s Site, layout []byte, categoryidx map[string]*Index)
 		}
 
 		index.Walk(func(id string) {
-			p, ok, err := previewPost(id, md, &buf)
+			p, ok, err := previewPost(id, s.markdown, &buf)
 
 			if err != nil {
 				walkerr = err
@@ -123,40 +245,95 @@ func publishCategoryIndex(s Site, layout []byte, categoryidx map[string]*Index)
 			return nil, walkerr
 		}
 
-		data := struct {
-			Site     Site
-			Category *Category
-			Posts    []*Post
-		}{
-			Site:     s,
-			Category: cat,
-			Posts:    posts,
+		pages := paginate(posts, perPage, cat.Href())
+
+		catpaths, err := publishIndex(s, "category-index", pages, func(pg *Paginator) interface{} {
+			return struct {
+				Site      Site
+				Category  *Category
+				Posts     []*Post
+				Paginator *Paginator
+			}{
+				Site:      s,
+				Category:  cat,
+				Posts:     pg.Posts,
+				Paginator: pg,
+			}
+		})
+
+		if err != nil {
+			return nil, err
 		}
+		paths = append(paths, catpaths...)
+	}
+	return paths, nil
+}
+
+func publishTagIndex(s Site, tagidx map[string]*Index, perPage int) ([]string, error) {
+	posts := make([]*Post, 0)
+	paths := make([]string, 0, len(tagidx))
+
+	var buf bytes.Buffer
+
+	for _, tag := range s.Tags {
+		var walkerr error
 
-		err = func(id string) error {
-			path := filepath.Join(siteDir, id, "index.html")
+		posts = posts[0:0]
 
-			f, err := os.Create(path)
+		index, ok := tagidx[tag.ID]
+
+		if !ok {
+			continue
+		}
+
+		index.Walk(func(id string) {
+			p, ok, err := previewPost(id, s.markdown, &buf)
 
 			if err != nil {
-				return err
+				walkerr = err
+				return
 			}
 
-			defer f.Close()
+			if !ok {
+				return
+			}
+			posts = append(posts, p)
+		})
 
-			paths = append(paths, path)
+		if walkerr != nil {
+			return nil, walkerr
+		}
 
-			return executeTemplate(f, id+"-index", string(layout), data)
-		}(id)
+		pages := paginate(posts, perPage, tag.Href())
+
+		tagpaths, err := publishIndex(s, "tag-index", pages, func(pg *Paginator) interface{} {
+			return struct {
+				Site      Site
+				Tag       *Tag
+				Posts     []*Post
+				Paginator *Paginator
+			}{
+				Site:      s,
+				Tag:       tag,
+				Posts:     pg.Posts,
+				Paginator: pg,
+			}
+		})
 
 		if err != nil {
 			return nil, err
 		}
+		paths = append(paths, tagpaths...)
 	}
 	return paths, nil
 }
 
-func publishFeed(s Site, index *Index, atom, rss string) error {
+// publishFeed writes the Atom and RSS feeds of the posts in the given index to
+// the given files, if any. The title and href are those of the page the feed is
+// for, such as the site index or a category index. If content is true then the
+// full content of each post is included in its item, along with its summary.
+// The paths of the feeds written are returned.
+func publishFeed(s Site, index *Index, title, href, atom, rss string, content bool) ([]string, error) {
 	items := make([]*feeds.Item, 0)
 
 	author := &feeds.Author{
@@ -164,17 +341,18 @@ func publishFeed(s Site, index *Index, atom, rss string) error {
 		Email: s.Author.Email,
 	}
 
-	var buf bytes.Buffer
-
-	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	var (
+		buf     bytes.Buffer
+		updated time.Time
+		walkerr error
 	)
 
-	var walkerr error
-
 	index.Walk(func(id string) {
-		p, ok, err := previewPost(id, md, &buf)
+		if walkerr != nil {
+			return
+		}
+
+		p, ok, err := previewPost(id, s.markdown, &buf)
 
 		if err != nil {
 			walkerr = err
@@ -185,73 +363,170 @@ func publishFeed(s Site, index *Index, atom, rss string) error {
 			return
 		}
 
-		items = append(items, &feeds.Item{
-			Title: s.Title,
+		link := s.Link + p.Href()
+
+		item := &feeds.Item{
+			Id:    link,
+			Title: p.Title,
 			Link: &feeds.Link{
-				Href: s.Link + p.Href(),
+				Href: link,
 			},
-			Description: strip.StripTags(p.Description),
+			Description: strip.StripTags(string(p.Summary)),
 			Author:      author,
 			Created:     p.CreatedAt.Time,
-		})
+			Updated:     p.UpdatedAt.Time,
+		}
+
+		if content {
+			body, err := render(s.markdown, p.Source)
+
+			if err != nil {
+				walkerr = err
+				return
+			}
+			item.Content = string(body)
+		}
+
+		if p.UpdatedAt.After(updated) {
+			updated = p.UpdatedAt.Time
+		}
+		items = append(items, item)
 	})
 
 	if walkerr != nil {
-		return walkerr
+		return nil, walkerr
 	}
 
+	// The feed is given the time of the most recently updated post, so the
+	// feed is only modified when the posts in it are.
 	feed := &feeds.Feed{
-		Title: s.Title,
+		Title: title,
 		Link: &feeds.Link{
-			Href: s.Link,
+			Href: s.Link + href,
 		},
 		Description: s.Description,
 		Author:      author,
+		Updated:     updated,
 		Items:       items,
 	}
 
+	paths := make([]string, 0, 2)
+
 	if atom != "" {
-		f, err := os.Create(atom)
+		f, err := s.create(atom)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer f.Close()
 
 		if err := feed.WriteAtom(f); err != nil {
-			return err
+			return nil, err
 		}
+		paths = append(paths, atom)
 	}
 
 	if rss != "" {
-		f, err := os.Create(rss)
+		f, err := s.create(rss)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer f.Close()
 
 		if err := feed.WriteRss(f); err != nil {
-			return err
+			return nil, err
+		}
+		paths = append(paths, rss)
+	}
+	return paths, nil
+}
+
+// feedFile returns the path to the feed file with the given name for the
+// index at the given href. An empty string is returned if the name is empty.
+func feedFile(href, name string) string {
+	if name == "" {
+		return ""
+	}
+	return filepath.Join(siteDir, filepath.FromSlash(href), name)
+}
+
+// feedTitle returns the title of the feed for the category or tag of the given
+// name, prefixed with the title of the site if it has one.
+func feedTitle(s Site, name string) string {
+	if s.Title == "" {
+		return name
+	}
+	return s.Title + " - " + name
+}
+
+// publishFeeds writes the site feed, and the feed for each category and tag if
+// configured to. The site feed is written to the given files if any, otherwise
+// to the files given by feed.atom and feed.rss.
+func publishFeeds(cfg *Config, s Site, index *Index, categoryidx, tagidx map[string]*Index, atom, rss string) ([]string, error) {
+	if atom == "" {
+		atom = feedFile("", cfg.Feed.Atom)
+	}
+
+	if rss == "" {
+		rss = feedFile("", cfg.Feed.RSS)
+	}
+
+	paths, err := publishFeed(s, index, s.Title, "", atom, rss, cfg.Feed.Content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Feed.Categories {
+		for _, cat := range s.Categories {
+			index, ok := categoryidx[cat.ID]
+
+			if !ok || index.Len() == 0 {
+				continue
+			}
+
+			href := cat.Href()
+
+			catpaths, err := publishFeed(s, index, feedTitle(s, cat.Name), href, feedFile(href, cfg.Feed.Atom), feedFile(href, cfg.Feed.RSS), cfg.Feed.Content)
+
+			if err != nil {
+				return nil, fmt.Errorf("category %s: %w", cat.ID, err)
+			}
+			paths = append(paths, catpaths...)
 		}
 	}
-	return nil
+
+	if cfg.Feed.Tags {
+		for _, tag := range s.Tags {
+			index, ok := tagidx[tag.ID]
+
+			if !ok || index.Len() == 0 {
+				continue
+			}
+
+			href := tag.Href()
+
+			tagpaths, err := publishFeed(s, index, feedTitle(s, tag.Name), href, feedFile(href, cfg.Feed.Atom), feedFile(href, cfg.Feed.RSS), cfg.Feed.Content)
+
+			if err != nil {
+				return nil, fmt.Errorf("tag %s: %w", tag.ID, err)
+			}
+			paths = append(paths, tagpaths...)
+		}
+	}
+	return paths, nil
 }
 
-func publishSiteIndex(s Site, layout []byte, index *Index) (string, error) {
+func publishSiteIndex(s Site, index *Index, perPage int) ([]string, error) {
 	var walkerr error
 
 	posts := make([]*Post, 0)
 
-	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
-	)
-
 	var buf bytes.Buffer
 
 	index.Walk(func(id string) {
-		p, ok, err := previewPost(id, md, &buf)
+		p, ok, err := previewPost(id, s.markdown, &buf)
 
 		if err != nil {
 			walkerr = err
@@ -265,31 +540,22 @@ func publishSiteIndex(s Site, layout []byte, index *Index) (string, error) {
 	})
 
 	if walkerr != nil {
-		return "", walkerr
-	}
-
-	data := struct {
-		Site  Site
-		Posts []*Post
-	}{
-		Site:  s,
-		Posts: posts,
+		return nil, walkerr
 	}
 
-	path := filepath.Join(siteDir, "index.html")
+	pages := paginate(posts, perPage, "/")
 
-	f, err := os.Create(path)
-
-	if err != nil {
-		return "", err
-	}
-
-	defer f.Close()
-
-	if err := executeTemplate(f, "site-index", string(layout), data); err != nil {
-		return "", err
-	}
-	return path, nil
+	return publishIndex(s, "index", pages, func(pg *Paginator) interface{} {
+		return struct {
+			Site      Site
+			Posts     []*Post
+			Paginator *Paginator
+		}{
+			Site:      s,
+			Posts:     pg.Posts,
+			Paginator: pg,
+		}
+	})
 }
 
 func publishPages(s Site) (chan *Page, chan error) {
@@ -402,42 +668,41 @@ func (d Directory) Hash() []byte {
 	return sha256.Sum(nil)
 }
 
-func (e publishError) Error() string {
-	return "failed to publish " + e.kind + " " + e.id + ": " + e.err.Error()
-}
-
-func publishCmd(cmd *Command, args []string) {
-	if err := initialized(""); err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+// dependsOn returns the dependencies of a page or post rendered with the given
+// layout. The text is the content of a page, since the content of a page is
+// executed as a template and may use partials.
+func dependsOn(cfg *Config, layouts *Layouts, h Hasher, layout, text string) Dependencies {
+	return Dependencies{
+		Hasher: h,
+		Sums:   [][]byte{cfg.Hash(), layouts.Sum(layout, text)},
 	}
+}
 
-	var (
-		atom    string
-		draft   bool
-		rss     string
-		verbose bool
-	)
+func (r *publishResult) add(paths ...string) {
+	r.paths = append(r.paths, paths...)
+	r.outputs = append(r.outputs, paths...)
+}
 
-	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
-	fs.StringVar(&atom, "a", "", "the file to write the Atom feed to")
-	fs.BoolVar(&draft, "d", false, "only publish the HTML, don't copy to the remote")
-	fs.StringVar(&rss, "r", "", "the file to write the RSS feed to")
-	fs.BoolVar(&verbose, "v", false, "display the files copied to the remote")
-	fs.Parse(args[1:])
+func (e publishError) Error() string {
+	return "failed to publish " + e.kind + " " + e.id + ": " + e.err.Error()
+}
 
-	cfg, err := OpenConfig()
+// publish renders the modified pages and posts of the journal into the _site
+// directory, along with the site index, category indexes, and feeds. The paths
+// of the files that should be copied to the remote are returned. Errors that
+// occur when publishing an individual page or post are passed to errh, and do
+// not stop the rest of the journal from being published.
+func publish(cfg *Config, hash *Hash, opts publishOptions, errh func(error)) (*publishResult, error) {
+	layouts, err := LoadLayouts(cfg.Site.Template == "text")
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to load layouts: %w", err)
 	}
 
 	categories, err := Categories()
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed to get all categories: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to get all categories: %w", err)
 	}
 
 	s := Site{
@@ -446,27 +711,28 @@ func publishCmd(cmd *Command, args []string) {
 		Link:        cfg.Site.Link,
 		Categories:  categories,
 		Pages:       make([]*Page, 0),
+		layouts:     layouts,
+		markdown:    newMarkdown(cfg),
+		dir:         opts.dir,
 	}
 	s.Author.Name = cfg.Author.Name
 	s.Author.Email = cfg.Author.Email
 
-	hash, err := OpenHash()
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed to open hash: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+	res := &publishResult{
+		paths:   make([]string, 0),
+		outputs: make([]string, 0),
 	}
 
-	defer hash.Close()
-
+	// Each page is an output whether or not it publishes successfully, so a
+	// page that fails is not removed from the remote as stale.
 	err = WalkPages(func(p *Page) error {
 		s.Pages = append(s.Pages, p)
+		res.outputs = append(res.outputs, p.SitePath)
 		return nil
 	})
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed walk pages: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed walk pages: %w", err)
 	}
 
 	index := NewIndex()
@@ -476,60 +742,76 @@ func publishCmd(cmd *Command, args []string) {
 		categoryidx[cat.ID] = NewIndex()
 	}
 
+	tagidx := make(map[string]*Index)
+
 	postset := make(map[string]struct{}, 0)
 
+	now := time.Now()
+
 	err = WalkPosts(func(p *Post) error {
+		if !opts.drafts && !p.Published(now) {
+			return nil
+		}
+
 		index.Put(p)
 
 		if id := p.Category.ID; id != "" {
 			categoryidx[id].Put(p)
 		}
 
-		if hash.Put(p.ID, p) {
+		for _, tag := range p.Tags {
+			if _, ok := tagidx[tag.ID]; !ok {
+				tagidx[tag.ID] = NewIndex()
+				s.Tags = append(s.Tags, tag)
+			}
+			tagidx[tag.ID].Put(p)
+		}
+
+		if hash.Put(p.ID, dependsOn(cfg, layouts, p, p.Layout, "")) {
 			postset[p.ID] = struct{}{}
 		}
+
+		res.outputs = append(res.outputs, p.SitePath)
 		return nil
 	})
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed walk posts: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed walk posts: %w", err)
 	}
 
-	paths := make([]string, 0)
-
-	if hash.Put(assetsDir, Directory(assetsDir)) {
-		err := filepath.Walk(assetsDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	sort.Slice(s.Tags, func(i, j int) bool {
+		return s.Tags[i].ID < s.Tags[j].ID
+	})
 
-			if !info.IsDir() {
-				paths = append(paths, path)
-			}
-			return nil
-		})
+	assets := make([]string, 0)
 
+	err = filepath.Walk(assetsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s: failed to walk assets directory: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+			return err
 		}
-	}
 
-	if err := publishFeed(s, index, atom, rss); err != nil {
-		fmt.Fprintf(os.Stderr, "%s %s: failed to publish feed: %s\n", cmd.Argv0, args[0], err)
-		os.Exit(1)
-	}
+		if !info.IsDir() {
+			assets = append(assets, path)
+		}
+		return nil
+	})
 
-	if atom != "" {
-		paths = append(paths, atom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk assets directory: %w", err)
 	}
 
-	if rss != "" {
-		paths = append(paths, rss)
+	res.outputs = append(res.outputs, assets...)
+
+	if hash.Put(assetsDir, Directory(assetsDir)) {
+		res.paths = append(res.paths, assets...)
 	}
 
-	code := 0
+	feedpaths, err := publishFeeds(cfg, s, index, categoryidx, tagidx, opts.atom, opts.rss)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish feed: %w", err)
+	}
+	res.add(feedpaths...)
 
 	pages, errs := publishPages(s)
 
@@ -541,16 +823,15 @@ func publishCmd(cmd *Command, args []string) {
 				break
 			}
 
-			if hash.Put(p.ID, p) {
-				paths = append(paths, p.SitePath)
+			if hash.Put(p.ID, dependsOn(cfg, layouts, p, p.Layout, p.Source)) {
+				res.paths = append(res.paths, p.SitePath)
 			}
 		case err, ok := <-errs:
 			if !ok {
 				errs = nil
 				break
 			}
-			code = 1
-			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+			errh(err)
 		}
 	}
 
@@ -563,65 +844,220 @@ func publishCmd(cmd *Command, args []string) {
 				posts = nil
 				break
 			}
-			paths = append(paths, p.SitePath)
+			res.paths = append(res.paths, p.SitePath)
 		case err, ok := <-errs:
 			if !ok {
 				errs = nil
 				break
 			}
-			code = 1
-			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+			errh(err)
 		}
 	}
 
-	layout, err := ioutil.ReadFile(filepath.Join(layoutsDir, "index"))
+	if layouts.Has("index") {
+		indexpaths, err := publishSiteIndex(s, index, cfg.Site.Paginate)
 
-	if err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "%s %s: failed read site index layout: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+		if err != nil {
+			return nil, fmt.Errorf("failed publish site index: %w", err)
 		}
+		res.add(indexpaths...)
 	}
 
-	if len(layout) > 0 {
-		path, err := publishSiteIndex(s, layout, index)
+	if layouts.Has("category-index") {
+		catpaths, err := publishCategoryIndex(s, categoryidx, cfg.Site.Paginate)
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s: failed publish site index: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+			return nil, fmt.Errorf("failed publish category index: %w", err)
 		}
-		paths = append(paths, path)
+		res.add(catpaths...)
 	}
 
-	layout, err = ioutil.ReadFile(filepath.Join(layoutsDir, "category-index"))
+	if layouts.Has("tag-index") {
+		tagpaths, err := publishTagIndex(s, tagidx, cfg.Site.Paginate)
 
-	if err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "%s %s: failed read category index layout: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+		if err != nil {
+			return nil, fmt.Errorf("failed publish tag index: %w", err)
 		}
+		res.add(tagpaths...)
 	}
 
-	if len(layout) > 0 {
-		catpaths, err := publishCategoryIndex(s, layout, categoryidx)
+	if cfg.Site.Link != "" {
+		smpaths, err := publishSitemap(s, index, cfg.Site.Robots)
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s: failed publish category index: %s\n", cmd.Argv0, args[0], err)
+			return nil, fmt.Errorf("failed to publish sitemap: %w", err)
+		}
+		res.add(smpaths...)
+	}
+	return res, nil
+}
+
+func publishCmd(cmd *Command, args []string) {
+	if err := initialized(""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	var (
+		opts    publishOptions
+		draft   bool
+		dryrun  bool
+		jobs    int
+		prune   bool
+		stale   bool
+		sync    bool
+		to      string
+		verbose bool
+	)
+
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.StringVar(&opts.atom, "a", "", "the file to write the Atom feed to")
+	fs.BoolVar(&draft, "d", false, "only publish the HTML, don't copy to the remote")
+	fs.BoolVar(&opts.drafts, "drafts", false, "publish draft and scheduled posts, implies -d")
+	fs.IntVar(&jobs, "j", 4, "the number of files to copy to the remote at once")
+	fs.BoolVar(&dryrun, "n", false, "show what would be copied to the remote without publishing")
+	fs.BoolVar(&prune, "prune", false, "remove files from the remote that are no longer published")
+	fs.StringVar(&opts.rss, "r", "", "the file to write the RSS feed to")
+	fs.BoolVar(&stale, "stale", false, "list the files -prune would remove, implies -d")
+	fs.BoolVar(&sync, "sync", false, "copy the files that differ from the manifest on the remote")
+	fs.StringVar(&to, "to", "", "the named remote to publish to")
+	fs.BoolVar(&verbose, "v", false, "display the files copied to the remote")
+	fs.Parse(args[1:])
+
+	cfg, err := OpenConfig()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if err := cfg.UseRemote(to); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if sync && cfg.Site.Releases > 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: -sync cannot be used when site.releases is set\n", cmd.Argv0, args[0])
+		os.Exit(1)
+	}
+
+	data := remoteDataDir(to)
+
+	// A dry run reads the hash and manifest without creating them, so the
+	// _data directory is left untouched.
+	openHash, openManifest := OpenHash, OpenManifest
+
+	if dryrun {
+		openHash, openManifest = ReadHash, ReadManifest
+	}
+
+	hash, err := openHash(data)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open hash: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	defer hash.Close()
+
+	manifest, err := openManifest(data)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open manifest: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	defer manifest.Close()
+
+	// When syncing, every page and post is rendered, so the _site directory
+	// can be compared to the manifest on the remote.
+	if sync {
+		hash.Reset()
+	}
+
+	if dryrun {
+		dir, err := ioutil.TempDir("", "jrnl-publish")
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to create temporary directory: %s\n", cmd.Argv0, args[0], err)
 			os.Exit(1)
 		}
-		paths = append(paths, catpaths...)
+
+		defer os.RemoveAll(dir)
+
+		opts.dir = dir
 	}
 
-	if draft {
-		fmt.Println("published draft to", siteDir)
+	code := 0
+
+	res, err := publish(cfg, hash, opts, func(err error) {
+		code = 1
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+
+		if dryrun {
+			os.RemoveAll(opts.dir)
+		}
+		os.Exit(1)
+	}
+
+	if dryrun {
+		if sync {
+			rem, err := OpenRemote(cfg.Site.Remote)
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: failed to open remote: %s\n", cmd.Argv0, args[0], err)
+				os.RemoveAll(opts.dir)
+				os.Exit(1)
+			}
+
+			plan, err := planSync(rem, res.outputs, opts.dir)
+
+			rem.Close()
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+				os.RemoveAll(opts.dir)
+				os.Exit(1)
+			}
+
+			for _, path := range plan.copy {
+				fmt.Println("copy", path)
+			}
+
+			for _, path := range plan.remove {
+				fmt.Println("remove", path)
+			}
+			os.RemoveAll(opts.dir)
+			os.Exit(code)
+		}
+
+		for _, path := range res.paths {
+			fmt.Println("copy", path)
+		}
+
+		if prune {
+			for _, path := range manifest.Stale(res.outputs) {
+				fmt.Println("remove", path)
+			}
+		}
+		os.RemoveAll(opts.dir)
 		os.Exit(code)
 	}
 
-	if code == 0 {
-		if err := hash.Save(); err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s: failed to save hash: %s\n", cmd.Argv0, args[0], err)
-			os.Exit(1)
+	if stale {
+		for _, path := range manifest.Stale(res.outputs) {
+			fmt.Println(path)
 		}
+		os.Exit(code)
+	}
+
+	if draft || opts.drafts {
+		fmt.Println("published draft to", siteDir)
+		os.Exit(code)
 	}
 
 	if cfg.Site.Remote == "" {
@@ -636,21 +1072,167 @@ func publishCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
-	defer rem.Close()
-
 	if verbose {
-		fmt.Println("publishing to remote", cfg.Site.Remote)
+		fmt.Println("publishing to remote", redactRemote(cfg.Site.Remote))
 	}
 
-	for _, path := range paths {
+	// The hash is only saved once every modified file has been copied to the
+	// remote, or the release has been activated. Otherwise a modified file
+	// that failed to be copied would be seen as unmodified by the next publish,
+	// and never copied.
+	if cfg.Site.Releases > 0 {
+		release, err := publishRelease(rem, res, cfg.Site.Releases, jobs, verbose)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to deploy release: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+
 		if verbose {
-			fmt.Println(path)
+			fmt.Println("activated release", release)
+		}
+
+		if err := rem.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to close remote: %s\n", cmd.Argv0, args[0], err)
+			code = 1
+		}
+
+		if code == 0 {
+			if err := hash.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: failed to save hash: %s\n", cmd.Argv0, args[0], err)
+				code = 1
+			}
+		}
+
+		for _, path := range manifest.Stale(res.outputs) {
+			manifest.Delete(path)
+		}
+
+		for _, path := range res.outputs {
+			manifest.Put(path)
+		}
+
+		if err := manifest.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to save manifest: %s\n", cmd.Argv0, args[0], err)
+			code = 1
+		}
+		os.Exit(code)
+	}
+
+	var (
+		n      int
+		failed []string
+		plan   *syncPlan
+	)
+
+	paths := res.paths
+
+	if sync {
+		plan, err = planSync(rem, res.outputs, "")
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+		paths = plan.copy
+	}
+
+	rem.CopyAll(paths, jobs, func(path string, err error) {
+		n++
+
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%q: %s", path, err))
+			return
+		}
+
+		if verbose {
+			fmt.Printf("[%d/%d] %s\n", n, len(paths), path)
+		}
+
+		if plan != nil {
+			plan.Copied(path)
+		}
+		manifest.Put(path)
+	})
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+
+		for _, msg := range failed {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to copy %s\n", cmd.Argv0, args[0], msg)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %d of %d file(s) failed to copy to remote\n", cmd.Argv0, args[0], len(failed), len(paths))
+		code = 1
+	}
+
+	stalepaths := manifest.Stale(res.outputs)
+
+	// When syncing, the files removed are those in the manifest on the
+	// remote that are no longer published, rather than those in the local
+	// manifest.
+	if sync {
+		stalepaths = plan.remove
+	}
+
+	if prune || sync {
+		// Files no longer in the manifest when the sync was planned may
+		// have since been copied by another publish, so nothing is
+		// removed if the manifest has changed.
+		if plan != nil {
+			if err := plan.Check(rem); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: %s, run '%s publish -sync' again\n", cmd.Argv0, args[0], err, cmd.Argv0)
+				os.Exit(1)
+			}
+		}
+
+		for _, path := range stalepaths {
+			if verbose {
+				fmt.Println("removing", path)
+			}
+
+			if err := rem.Remove(path); err != nil {
+				if !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "%s %s: failed to remove %q from remote: %s\n", cmd.Argv0, args[0], path, err)
+					code = 1
+					continue
+				}
+			}
+
+			if plan != nil {
+				plan.Removed(path)
+			}
+			manifest.Delete(path)
 		}
 
-		if err := rem.Copy(path); err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s: failed to copy %q to remote: %s\n", cmd.Argv0, args[0], path, err)
+		if plan != nil {
+			if err := plan.Save(rem); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: failed to write %s to remote: %s\n", cmd.Argv0, args[0], syncManifest, err)
+				code = 1
+			}
+		}
+	} else if verbose && len(stalepaths) > 0 {
+		fmt.Printf("%d stale file(s) on remote, run with -prune to remove them\n", len(stalepaths))
+	}
+
+	// Some remotes, such as git, only store the copied files once closed. If
+	// that fails then nothing was stored, so the hash is removed to have
+	// everything copied again by the next publish.
+	if err := rem.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to close remote: %s\n", cmd.Argv0, args[0], err)
+		os.Remove(filepath.Join(data, "hash"))
+		os.Exit(1)
+	}
+
+	if code == 0 {
+		if err := hash.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to save hash: %s\n", cmd.Argv0, args[0], err)
 			code = 1
 		}
 	}
+
+	if err := manifest.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to save manifest: %s\n", cmd.Argv0, args[0], err)
+		code = 1
+	}
 	os.Exit(code)
 }
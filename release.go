@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// releaseFS is an FS that supports atomic deploys. Each deploy is uploaded to
+// a release directory on the remote, and the current symlink is then pointed
+// to that release.
+type releaseFS interface {
+	FS
+
+	// Link creates newpath as a hard link to oldpath.
+	Link(oldpath, newpath string) error
+
+	// Symlink atomically points the symlink at the given path to the given
+	// target, replacing the symlink if it already exists.
+	Symlink(target, path string) error
+
+	// Readlink returns the target of the symlink at the given path.
+	Readlink(path string) (string, error)
+
+	// ReadDir returns the names of the entries in the given directory.
+	ReadDir(path string) ([]string, error)
+
+	// MkdirAll creates the given directory, along with any parents.
+	MkdirAll(path string) error
+
+	// RemoveAll removes the given path, and everything beneath it.
+	RemoveAll(path string) error
+}
+
+const (
+	releasesDir = "releases"
+	currentLink = "current"
+)
+
+func (r *Remote) releaseFS() (releaseFS, error) {
+	fs, ok := r.fs.(releaseFS)
+
+	if !ok {
+		return nil, errors.New("remote does not support releases")
+	}
+	return fs, nil
+}
+
+// Releases returns the releases on the remote, oldest first.
+func (r *Remote) Releases() ([]string, error) {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := fs.ReadDir(releasesDir)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Strings(releases)
+	return releases, nil
+}
+
+// Current returns the release the current symlink points to. An empty string
+// is returned if there is no current release.
+func (r *Remote) Current() (string, error) {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return "", err
+	}
+
+	target, err := fs.Readlink(currentLink)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return path.Base(target), nil
+}
+
+// Begin starts a new release on the remote. Every file copied to the remote
+// will be copied into the new release, until the release is activated.
+func (r *Remote) Begin() (string, error) {
+	releases, err := r.Releases()
+
+	if err != nil {
+		return "", err
+	}
+
+	// Releases are named to the nanosecond, so publishing twice in the same
+	// second does not collide, and the names still sort in the order they
+	// were deployed in.
+	release := time.Now().UTC().Format("20060102150405.000000000")
+
+	// Copying into an existing release would overwrite the files that are
+	// hard linked into the releases after it.
+	if i := sort.SearchStrings(releases, release); i < len(releases) && releases[i] == release {
+		return "", errors.New("release " + release + " already exists")
+	}
+
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return "", err
+	}
+
+	// The release is created up front, so a release without any files can
+	// still be activated.
+	if err := fs.MkdirAll(releasesDir + "/" + release); err != nil {
+		return "", err
+	}
+
+	r.release = release
+	return r.release, nil
+}
+
+// Carry carries the given file over from the given release into the release
+// being deployed. The file is hard linked, so it does not have to be copied to
+// the remote again.
+func (r *Remote) Carry(release, path string) error {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return err
+	}
+
+	path = strings.Replace(path, siteDir, "", 1)
+
+	return fs.Link(releasesDir+"/"+release+path, releasesDir+"/"+r.release+path)
+}
+
+// Activate points the current symlink to the given release.
+func (r *Remote) Activate(release string) error {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return err
+	}
+
+	releases, err := r.Releases()
+
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(releases, release)
+
+	if i == len(releases) || releases[i] != release {
+		return errors.New("no such release " + release)
+	}
+	return fs.Symlink(releasesDir+"/"+release, currentLink)
+}
+
+// Abort removes the release being deployed from the remote.
+func (r *Remote) Abort() error {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return err
+	}
+
+	if r.release == "" {
+		return nil
+	}
+
+	err = fs.RemoveAll(releasesDir + "/" + r.release)
+	r.release = ""
+	return err
+}
+
+// Prune removes the oldest releases from the remote, keeping the given number
+// of releases. The current release is never removed.
+func (r *Remote) Prune(keep int) error {
+	fs, err := r.releaseFS()
+
+	if err != nil {
+		return err
+	}
+
+	releases, err := r.Releases()
+
+	if err != nil {
+		return err
+	}
+
+	current, err := r.Current()
+
+	if err != nil {
+		return err
+	}
+
+	for len(releases) > keep {
+		release := releases[0]
+		releases = releases[1:]
+
+		if release == current {
+			continue
+		}
+
+		if err := fs.RemoveAll(releasesDir + "/" + release); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishRelease deploys every output of the published journal to a new
+// release on the remote, and then activates it. Outputs that have not been
+// modified are carried over from the current release, falling back to being
+// copied if that fails. The outputs are deployed using the given number of
+// concurrent jobs. If any output fails to deploy then the new release is
+// removed, and the current release is left as is. Once activated, the oldest
+// releases are removed, keeping the given number of releases.
+func publishRelease(rem *Remote, res *publishResult, keep, jobs int, verbose bool) (string, error) {
+	current, err := rem.Current()
+
+	if err != nil {
+		return "", err
+	}
+
+	release, err := rem.Begin()
+
+	if err != nil {
+		return "", err
+	}
+
+	modified := make(map[string]struct{}, len(res.paths))
+
+	for _, path := range res.paths {
+		modified[path] = struct{}{}
+	}
+
+	outputs := make([]string, 0, len(res.outputs))
+	seen := make(map[string]struct{}, len(res.outputs))
+
+	for _, path := range res.outputs {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+
+		seen[path] = struct{}{}
+		outputs = append(outputs, path)
+	}
+
+	deploy := func(path string) error {
+		if _, ok := modified[path]; !ok && current != "" {
+			if err := rem.Carry(current, path); err == nil {
+				return nil
+			}
+		}
+		return rem.Copy(path)
+	}
+
+	var (
+		n      int
+		failed int
+		first  error
+	)
+
+	parallel(outputs, jobs, deploy, func(path string, err error) {
+		n++
+
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("failed to copy %q to remote: %w", path, err)
+			}
+			failed++
+			return
+		}
+
+		if verbose {
+			fmt.Printf("[%d/%d] %s\n", n, len(outputs), path)
+		}
+	})
+
+	if first != nil {
+		rem.Abort()
+
+		if failed > 1 {
+			return "", fmt.Errorf("%w, and %d other file(s)", first, failed-1)
+		}
+		return "", first
+	}
+
+	if err := rem.Activate(release); err != nil {
+		rem.Abort()
+		return "", err
+	}
+
+	if err := rem.Prune(keep); err != nil {
+		return "", fmt.Errorf("failed to prune releases: %w", err)
+	}
+	return release, nil
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-
-	pkgsftp "github.com/pkg/sftp"
-
-	"golang.org/x/crypto/ssh"
+	"sync"
 )
 
 type File interface {
@@ -30,63 +29,65 @@ type FS interface {
 	Close() error
 }
 
-type disk struct {
-	path string
-}
-
-type sftp struct {
-	cli  *pkgsftp.Client
-	path string
-}
+// OpenFSFunc opens the FS for the remote at the given URL.
+type OpenFSFunc func(u *url.URL) (FS, error)
 
 type Remote struct {
 	fs FS
-}
 
-func getPublicKey(host string) (ssh.PublicKey, error) {
-	f, err := os.Open(filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"))
+	// release is the release being deployed, if any. Files copied to the
+	// remote are copied into the release.
+	release string
+}
 
-	if err != nil {
-		return nil, err
+var (
+	RemoteLsCmd = &Command{
+		Usage: "ls",
+		Short: "list the journal's named remotes",
+		Run:   remoteLsCmd,
 	}
 
-	defer f.Close()
-
-	sc := bufio.NewScanner(f)
-
-	var pubkey ssh.PublicKey
-
-	for sc.Scan() {
-		parts := strings.Split(sc.Text(), " ")
+	RemoteAddCmd = &Command{
+		Usage: "add [-l link] [-r releases] <name> <url>",
+		Short: "add a named remote",
+		Run:   remoteAddCmd,
+	}
 
-		if len(parts) != 3 {
-			continue
-		}
+	RemoteRmCmd = &Command{
+		Usage: "rm <name,...>",
+		Short: "remove the given named remotes",
+		Run:   remoteRmCmd,
+	}
+)
 
-		if strings.Contains(parts[0], host) {
-			pubkey, _, _, _, err = ssh.ParseAuthorizedKey(sc.Bytes())
+// remotefs is the registry of the functions for opening the FS of a remote,
+// keyed by the scheme of the remote's URL.
+var remotefs = make(map[string]OpenFSFunc)
 
-			if err != nil {
-				return nil, err
-			}
-			break
-		}
+// RegisterFS registers the function for opening the FS of remotes with the
+// given URL scheme. This should be called from an init function.
+func RegisterFS(scheme string, fn OpenFSFunc) {
+	if _, ok := remotefs[scheme]; ok {
+		panic("remote fs already registered for scheme " + scheme)
 	}
+	remotefs[scheme] = fn
+}
 
-	if pubkey == nil {
-		return nil, errors.New("no key for host: " + host)
+// parseRemote parses the given remote into a URL. A remote without a scheme
+// is either an absolute path on disk, or an SCP URL in the form of
+// user@host:path.
+func parseRemote(remote string) (*url.URL, error) {
+	if strings.Contains(remote, "://") {
+		return url.Parse(remote)
 	}
-	return pubkey, nil
-}
 
-func OpenRemote(remote string) (*Remote, error) {
 	if filepath.IsAbs(remote) {
-		return &Remote{
-			fs: &disk{path: remote},
+		return &url.URL{
+			Scheme: "file",
+			Path:   filepath.ToSlash(remote),
 		}, nil
 	}
 
-	// Assume SFTP.
 	var (
 		n int
 
@@ -111,181 +112,322 @@ func OpenRemote(remote string) (*Remote, error) {
 		return nil, errors.New("missing host in remote url")
 	}
 
-	if path == "" {
-		path = "/home/" + user
+	u := &url.URL{
+		Scheme: "sftp",
+		Host:   host,
+		Path:   path,
 	}
 
-	privkey, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"))
+	if user != "" {
+		u.User = url.User(user)
+	}
+	return u, nil
+}
+
+// redactRemote returns the given remote URL with any password in it replaced,
+// so the remote can be printed without leaking the secret key of an S3 remote,
+// or the password of a WebDAV remote.
+func redactRemote(remote string) string {
+	u, err := parseRemote(remote)
 
 	if err != nil {
-		return nil, err
+		return remote
+	}
+
+	if _, ok := u.User.Password(); !ok {
+		return remote
 	}
+	return u.Redacted()
+}
 
-	signer, err := ssh.ParsePrivateKey(privkey)
+// remoteDataDir returns the directory the hash and manifest of the given named
+// remote are stored in. Each named remote has its own hash and manifest, since
+// each remote may have been published to at a different time. The hash and
+// manifest of site.remote, which has no name, are stored in _data itself.
+func remoteDataDir(name string) string {
+	if name == "" {
+		return dataDir
+	}
+	return filepath.Join(dataDir, "remote", name)
+}
+
+// OpenRemote opens the given remote. The FS used for the remote is determined
+// by the scheme of the remote's URL.
+func OpenRemote(remote string) (*Remote, error) {
+	u, err := parseRemote(remote)
 
 	if err != nil {
 		return nil, err
 	}
 
-	pubkey, err := getPublicKey(host)
+	open, ok := remotefs[u.Scheme]
 
-	if err != nil {
-		return nil, err
+	if !ok {
+		return nil, errors.New("unknown remote scheme: " + u.Scheme)
 	}
 
-	conn, err := ssh.Dial("tcp", host+":22", &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.FixedHostKey(pubkey),
-	})
+	fs, err := open(u)
 
 	if err != nil {
 		return nil, err
 	}
+	return &Remote{fs: fs}, nil
+}
 
-	cli, err := pkgsftp.NewClient(conn)
+func (r *Remote) Copy(path string) error {
+	src, err := os.Open(path)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Remote{
-		fs: &sftp{
-			cli:  cli,
-			path: path,
-		},
-	}, nil
-}
+	defer src.Close()
+
+	path = strings.Replace(path, siteDir, "", 1)
 
-func (d *disk) filepath(path string) string { return filepath.Join(d.path, path) }
+	if r.release != "" {
+		path = releasesDir + "/" + r.release + path
+	}
 
-func (d *disk) Open(path string) (File, error) {
-	path = d.filepath(path)
+	dst, err := r.fs.Open(path)
 
-	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
-		return nil, err
+	if err != nil {
+		return err
 	}
-	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(0644))
+
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
 }
 
-func (d *disk) Remove(path string) error {
-	path = d.filepath(path)
+// CopyAll copies the given paths to the remote using n concurrent workers.
+// The done function is called with the result of each copy as it completes.
+func (r *Remote) CopyAll(paths []string, n int, done func(path string, err error)) {
+	parallel(paths, n, r.Copy, done)
+}
 
-	if err := os.Remove(path); err != nil {
-		return err
+// parallel calls fn for each of the given paths using n concurrent workers.
+// The done function is called with the result of each call as it completes,
+// from the calling goroutine, so done does not need to be safe for concurrent
+// use.
+func parallel(paths []string, n int, fn func(path string) error, done func(path string, err error)) {
+	type result struct {
+		path string
+		err  error
 	}
 
-	parts := strings.Split(filepath.Dir(path), string(os.PathSeparator))
+	if n < 1 {
+		n = 1
+	}
 
-	for i := range parts {
-		dir := string(os.PathSeparator) + filepath.Join(parts[:len(parts)-i]...)
+	jobs := make(chan string)
+	results := make(chan result)
 
-		if dir == d.path {
-			break
-		}
+	var wg sync.WaitGroup
 
-		info, err := ioutil.ReadDir(dir)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
 
-		if err != nil {
-			return err
-		}
+		go func() {
+			defer wg.Done()
 
-		if len(info) == 0 {
-			if err := os.Remove(dir); err != nil {
-				return err
+			for path := range jobs {
+				results <- result{
+					path: path,
+					err:  fn(path),
+				}
 			}
+		}()
+	}
+
+	go func() {
+		for _, path := range paths {
+			jobs <- path
 		}
+		close(jobs)
+
+		wg.Wait()
+		close(results)
+	}()
+
+	for res := range results {
+		done(res.path, res.err)
 	}
-	return nil
 }
 
-func (d *disk) Close() error { return nil }
-
-func (s *sftp) filepath(path string) string { return filepath.Join(s.path, path) }
+func (r *Remote) Remove(path string) error {
+	path = strings.Replace(path, siteDir, "", 1)
+	return r.fs.Remove(path)
+}
 
-func (s *sftp) Open(path string) (File, error) {
-	path = s.filepath(path)
+func (r *Remote) Close() error { return r.fs.Close() }
 
-	if err := s.cli.MkdirAll(filepath.Dir(path)); err != nil {
-		return nil, err
+func RemoteCmd(argv0 string) *Command {
+	cmd := &Command{
+		Usage: "remote <command> [arguments]",
+		Short: "manage the journal's remotes",
+		Run:   remoteCmd,
+		Commands: &CommandSet{
+			Argv0: argv0 + " remote",
+		},
 	}
 
-	f, err := s.cli.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR)
+	cmd.Commands.Add("ls", RemoteLsCmd)
+	cmd.Commands.Add("add", RemoteAddCmd)
+	cmd.Commands.Add("rm", RemoteRmCmd)
+	return cmd
+}
+
+func remoteLsCmd(cmd *Command, args []string) {
+	cfg, err := OpenConfig()
 
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
 
-		if err := s.cli.MkdirAll(path); err != nil {
-			return nil, err
-		}
-		f, err = s.cli.Create(path)
+	names := make([]string, 0, len(cfg.Remote))
+
+	for name := range cfg.Remote {
+		names = append(names, name)
 	}
-	return f, err
-}
 
-func (s *sftp) Remove(path string) error {
-	path = s.filepath(path)
+	sort.Strings(names)
 
-	if err := s.cli.Remove(path); err != nil {
-		return err
+	for _, name := range names {
+		fmt.Printf("%s\t%s\n", name, redactRemote(cfg.Remote[name].URL))
 	}
+}
 
-	parts := strings.Split(filepath.Dir(path), string(os.PathSeparator))
+func remoteAddCmd(cmd *Command, args []string) {
+	var (
+		link     string
+		releases int
+	)
 
-	for i := range parts {
-		dir := string(os.PathSeparator) + filepath.Join(parts[:len(parts)-i]...)
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.StringVar(&link, "l", "", "the link of the site when published to the remote")
+	fs.IntVar(&releases, "r", 0, "the number of releases to keep on the remote")
+	fs.Parse(args[1:])
 
-		if dir == s.path {
-			break
-		}
+	if fs.NArg() < 2 {
+		fmt.Fprintf(os.Stderr, "%s %s: usage: %s\n", cmd.Argv0, args[0], cmd.Usage)
+		os.Exit(1)
+	}
 
-		info, err := s.cli.ReadDir(dir)
+	name := fs.Arg(0)
 
-		if err != nil {
-			return err
-		}
+	if !reremote.MatchString(name) {
+		fmt.Fprintf(os.Stderr, "%s %s: invalid remote name %s\n", cmd.Argv0, args[0], name)
+		os.Exit(1)
+	}
 
-		if len(info) == 0 {
-			if err := s.cli.Remove(dir); err != nil {
-				return err
-			}
-		}
+	if releases < 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: releases must be a number\n", cmd.Argv0, args[0])
+		os.Exit(1)
 	}
-	return nil
-}
 
-func (s *sftp) Close() error { return s.cli.Close() }
+	u, err := parseRemote(fs.Arg(1))
 
-func (r *Remote) Copy(path string) error {
-	src, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: invalid remote url: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if _, ok := remotefs[u.Scheme]; !ok {
+		fmt.Fprintf(os.Stderr, "%s %s: unknown remote scheme: %s\n", cmd.Argv0, args[0], u.Scheme)
+		os.Exit(1)
+	}
+
+	cfg, err := OpenConfig()
 
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
 	}
 
-	defer src.Close()
+	defer cfg.Close()
 
-	path = strings.Replace(path, siteDir, "", 1)
+	if _, ok := cfg.Remote[name]; ok {
+		fmt.Fprintf(os.Stderr, "%s %s: remote %s already exists\n", cmd.Argv0, args[0], name)
+		os.Exit(1)
+	}
 
-	dst, err := r.fs.Open(path)
+	if cfg.Remote == nil {
+		cfg.Remote = make(map[string]RemoteConfig)
+	}
+
+	cfg.Remote[name] = RemoteConfig{
+		URL:      fs.Arg(1),
+		Link:     link,
+		Releases: releases,
+	}
+
+	if err := cfg.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to save config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+}
+
+func remoteRmCmd(cmd *Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "%s %s: usage: %s\n", cmd.Argv0, args[0], cmd.Usage)
+		os.Exit(1)
+	}
+
+	cfg, err := OpenConfig()
 
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
 	}
 
-	defer dst.Close()
+	defer cfg.Close()
 
-	_, err = io.Copy(dst, src)
-	return err
-}
+	code := 0
 
-func (r *Remote) Remove(path string) error {
-	path = strings.Replace(path, siteDir, "", 1)
-	return r.fs.Remove(path)
+	for _, name := range args[1:] {
+		if _, ok := cfg.Remote[name]; !ok || !reremote.MatchString(name) {
+			fmt.Fprintf(os.Stderr, "%s %s: unknown remote %s\n", cmd.Argv0, args[0], name)
+			code = 1
+			continue
+		}
+
+		delete(cfg.Remote, name)
+
+		if err := os.RemoveAll(remoteDataDir(name)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to remove data for remote %s: %s\n", cmd.Argv0, args[0], name, err)
+			code = 1
+		}
+	}
+
+	if err := cfg.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to save config: %s\n", cmd.Argv0, args[0], err)
+		code = 1
+	}
+	os.Exit(code)
 }
 
-func (r *Remote) Close() error { return r.fs.Close() }
+func remoteCmd(cmd *Command, args []string) {
+	if err := initialized(""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if len(args) < 2 {
+		cfg, err := OpenConfig()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+		fmt.Println(redactRemote(cfg.Site.Remote))
+		return
+	}
+
+	if err := cmd.Commands.Parse(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 )
 
 var RmCmd = &Command{
-	Usage: "rm <page|post,...>",
+	Usage: "rm [-to remote] <page|post,...>",
 	Short: "remove the given page or post",
 	Long: `Rm will remove the given page or post. This will remove the generated site page
 too if one exists. If a post is removed that would be the last post in a given
-category, then that category will be removed too.`,
+category, then that category will be removed too.
+
+The removed pages and posts are removed from site.remote, or from the given
+named remote if the -to flag is given. If site.releases is set then nothing is
+removed from the remote, since releases are never modified once deployed.
+Instead, publish the journal to deploy a new release without them.`,
 	Run: rmCmd,
 }
 
@@ -20,7 +26,13 @@ func rmCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
-	if len(args) < 2 {
+	var to string
+
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.StringVar(&to, "to", "", "the named remote to remove from")
+	fs.Parse(args[1:])
+
+	if fs.NArg() < 1 {
 		fmt.Fprintf(os.Stderr, "%s %s: usage: %s\n", cmd.Argv0, args[0], cmd.Usage)
 		os.Exit(1)
 	}
@@ -32,11 +44,16 @@ func rmCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
+	if err := cfg.UseRemote(to); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
 	code := 0
 
-	rmpaths := make([]string, 0, len(args[1:]))
+	rmpaths := make([]string, 0, fs.NArg())
 
-	for _, id := range args[1:] {
+	for _, id := range fs.Args() {
 		page, ok, err := GetPage(id)
 
 		if err != nil {
@@ -75,6 +92,11 @@ func rmCmd(cmd *Command, args []string) {
 		rmpaths = append(rmpaths, page.SitePath)
 	}
 
+	if cfg.Site.Releases > 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: site.releases is set, run '%s publish' to remove from the remote\n", cmd.Argv0, args[0], cmd.Argv0)
+		os.Exit(code)
+	}
+
 	rem, err := OpenRemote(cfg.Site.Remote)
 
 	if err != nil {
@@ -82,15 +104,42 @@ func rmCmd(cmd *Command, args []string) {
 		os.Exit(1)
 	}
 
-	defer rem.Close()
+	manifest, err := OpenManifest(remoteDataDir(to))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open manifest: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	defer manifest.Close()
+
+	removed := make([]string, 0, len(rmpaths))
 
 	for _, path := range rmpaths {
 		if err := rem.Remove(path); err != nil {
 			if !os.IsNotExist(err) {
 				fmt.Fprintf(os.Stderr, "%s %s: failed to remove %q from remote: %s\n", cmd.Argv0, args[0], path, err)
 				code = 1
+				continue
 			}
 		}
+		manifest.Delete(path)
+		removed = append(removed, path)
+	}
+
+	if err := unsync(rem, removed); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to update %s on remote: %s\n", cmd.Argv0, args[0], syncManifest, err)
+		code = 1
+	}
+
+	if err := rem.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to close remote: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if err := manifest.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to save manifest: %s\n", cmd.Argv0, args[0], err)
+		code = 1
 	}
 	os.Exit(code)
 }
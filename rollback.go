@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var RollbackCmd = &Command{
+	Usage: "rollback [-l] [-to remote] [release]",
+	Short: "point the remote to a previous release",
+	Long: `Rollback will point the current symlink on the remote to the given release. If
+no release is given then the release before the current release is used. This
+requires site.releases to be set, so that the journal is deployed atomically.
+Since the remote no longer reflects the hashed content of the journal, the
+_data/hash file is removed, so the next publish will deploy everything.
+
+The -l flag will list the releases on the remote instead, the current release
+will be marked with a *.
+
+The -to flag will roll back the given named remote instead of site.remote, in
+which case the hash of that remote is removed.`,
+	Run: rollbackCmd,
+}
+
+func rollbackCmd(cmd *Command, args []string) {
+	if err := initialized(""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	var (
+		list bool
+		to   string
+	)
+
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.BoolVar(&list, "l", false, "list the releases on the remote")
+	fs.StringVar(&to, "to", "", "the named remote to roll back")
+	fs.Parse(args[1:])
+
+	cfg, err := OpenConfig()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if err := cfg.UseRemote(to); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if cfg.Site.Remote == "" {
+		fmt.Fprintf(os.Stderr, "%s %s: remote not set, set with '%s config site.remote'\n", cmd.Argv0, args[0], cmd.Argv0)
+		os.Exit(1)
+	}
+
+	rem, err := OpenRemote(cfg.Site.Remote)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to open remote: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	defer rem.Close()
+
+	releases, err := rem.Releases()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to get releases: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	current, err := rem.Current()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to get current release: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	if list {
+		for _, release := range releases {
+			if release == current {
+				fmt.Println("*", release)
+				continue
+			}
+			fmt.Println(" ", release)
+		}
+		return
+	}
+
+	release := fs.Arg(0)
+
+	if release == "" {
+		for i, r := range releases {
+			if r == current {
+				if i > 0 {
+					release = releases[i-1]
+				}
+				break
+			}
+		}
+
+		if release == "" {
+			fmt.Fprintf(os.Stderr, "%s %s: no release before the current release\n", cmd.Argv0, args[0])
+			os.Exit(1)
+		}
+	}
+
+	if err := rem.Activate(release); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to activate release: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	// The hash no longer reflects what is on the remote, so it is removed to
+	// have the next publish deploy everything.
+	if err := os.Remove(filepath.Join(remoteDataDir(to), "hash")); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to remove hash: %s\n", cmd.Argv0, args[0], err)
+			os.Exit(1)
+		}
+	}
+	fmt.Println("rolled back to release", release)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// s3 is an FS for an S3 compatible object store. Each file is uploaded as an
+// object in the bucket beneath the prefix. Requests are signed with AWS
+// Signature Version 4.
+type s3 struct {
+	cli      *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	vhost    bool // vhost denotes whether the bucket is in the endpoint's host.
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// s3File is a file being written to an S3 bucket. The object is uploaded when
+// the file is closed.
+type s3File struct {
+	bytes.Buffer
+
+	fs   *s3
+	path string
+}
+
+type s3Error struct {
+	status int
+	body   string
+}
+
+func init() {
+	RegisterFS("s3", openS3)
+}
+
+// getenv returns the value of the first environment variable that is set.
+func getenv(keys ...string) string {
+	for _, key := range keys {
+		if val := os.Getenv(key); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// openS3 opens the bucket for the given s3:// URL. The host of the URL is the
+// bucket, and the path is the prefix the files are uploaded beneath. The
+// endpoint and region can be given via the endpoint and region query
+// parameters, otherwise they are taken from the AWS_ENDPOINT_URL and
+// AWS_REGION environment variables. The credentials can be given in the URL,
+// otherwise they are taken from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// and AWS_SESSION_TOKEN environment variables.
+func openS3(u *url.URL) (FS, error) {
+	if u.Host == "" {
+		return nil, errors.New("missing bucket in remote url")
+	}
+
+	q := u.Query()
+
+	fs := &s3{
+		cli:          &http.Client{Timeout: time.Minute},
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       q.Get("region"),
+		accessKey:    u.User.Username(),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if fs.region == "" {
+		fs.region = getenv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+
+	if fs.region == "" {
+		fs.region = "us-east-1"
+	}
+
+	if secret, ok := u.User.Password(); ok {
+		fs.secretKey = secret
+	}
+
+	if fs.accessKey == "" {
+		fs.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		fs.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if fs.accessKey == "" || fs.secretKey == "" {
+		return nil, errors.New("missing s3 credentials")
+	}
+
+	endpoint := q.Get("endpoint")
+
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	// Without an endpoint we assume AWS, which prefers the bucket being
+	// given in the host. Other S3 compatible stores, such as MinIO, expect
+	// the bucket in the path.
+	if endpoint == "" {
+		endpoint = "https://" + fs.bucket + ".s3." + fs.region + ".amazonaws.com"
+		fs.vhost = true
+	}
+
+	var err error
+
+	fs.endpoint, err = url.Parse(endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.endpoint.Host == "" {
+		return nil, errors.New("invalid s3 endpoint: " + endpoint)
+	}
+	return fs, nil
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: unexpected status %d: %s", e.status, e.body)
+}
+
+// escapePath escapes the given path as required for the canonical request of
+// the signature. Only the unreserved characters and forward slashes are left
+// as is.
+func escapePath(s string) string {
+	var buf strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// key returns the key of the object for the given path.
+func (s *s3) key(p string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, filepath.ToSlash(p)), "/")
+}
+
+// objectURL returns the URL for the object with the given key.
+func (s *s3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+
+	if s.vhost {
+		u.Path = "/" + key
+	} else {
+		u.Path = path.Join("/", u.Path, s.bucket) + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	return &u
+}
+
+// sign signs the given request, and the body of that request, with AWS
+// Signature Version 4.
+func (s *s3) sign(r *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+
+	amzdate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+
+	r.Header.Set("X-Amz-Date", amzdate)
+	r.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := []string{r.URL.Host, payload, amzdate}
+
+	if s.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.sessionToken)
+
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, s.sessionToken)
+	}
+
+	var canonical strings.Builder
+
+	canonical.WriteString(r.Method + "\n")
+	canonical.WriteString(r.URL.EscapedPath() + "\n")
+	canonical.WriteString(r.URL.Query().Encode() + "\n")
+
+	for i, h := range headers {
+		canonical.WriteString(h + ":" + values[i] + "\n")
+	}
+
+	signed := strings.Join(headers, ";")
+
+	canonical.WriteString("\n" + signed + "\n" + payload)
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum = sha256.Sum256([]byte(canonical.String()))
+
+	tosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, tosign))
+
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func (s *s3) do(method, key string, body []byte, hdr http.Header) error {
+	_, err := s.request(method, key, body, hdr)
+	return err
+}
+
+// request sends a signed request for the object with the given key, and
+// returns the body of the response.
+func (s *s3) request(method, key string, body []byte, hdr http.Header) ([]byte, error) {
+	u := s.objectURL(key)
+
+	r, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range hdr {
+		r.Header[k] = v
+	}
+
+	s.sign(r, body, time.Now())
+
+	resp, err := s.cli.Do(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, &s3Error{
+			status: resp.StatusCode,
+			body:   strings.TrimSpace(string(b)),
+		}
+	}
+	return b, nil
+}
+
+func (s *s3) Open(path string) (File, error) {
+	return &s3File{
+		fs:   s,
+		path: path,
+	}, nil
+}
+
+// Remove deletes the object for the given path. Unlike a directory on disk,
+// there are no empty directories to remove afterwards.
+func (s *s3) Remove(path string) error {
+	return s.do(http.MethodDelete, s.key(path), nil, nil)
+}
+
+func (s *s3) Close() error { return nil }
+
+func (s *s3) ReadFile(path string) ([]byte, error) {
+	b, err := s.request(http.MethodGet, s.key(path), nil, nil)
+
+	if err != nil {
+		var s3err *s3Error
+
+		if errors.As(err, &s3err) && s3err.status == http.StatusNotFound {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close uploads the contents of the file to the bucket. The Content-Type of
+// the object is determined from the file extension, falling back to sniffing
+// the contents of the file.
+func (f *s3File) Close() error {
+	b := f.Bytes()
+
+	typ := mime.TypeByExtension(filepath.Ext(f.path))
+
+	if typ == "" {
+		typ = http.DetectContentType(b)
+	}
+
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", typ)
+
+	return f.fs.do(http.MethodPut, f.fs.key(f.path), b, hdr)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// watcher polls a set of files and directories for changes to the files
+// within them.
+type watcher struct {
+	dirs  []string
+	files map[string]fileStat
+}
+
+// reloader sends reload events to the browser tabs that are listening on the
+// reload path.
+type reloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+type server struct {
+	files    http.Handler
+	reloader *reloader
+}
+
+var (
+	reloadPath   = "/_jrnl/reload"
+	reloadScript = []byte(`<script>new EventSource("` + reloadPath + `").onmessage = function() { location.reload(); };</script>`)
+
+	ServeCmd = &Command{
+		Usage: "serve [options]",
+		Short: "serve the journal locally for previewing",
+		Long: `Serve will publish the journal to the _site directory as a draft, and serve the
+_site directory over HTTP. Draft and scheduled posts will be published too. The
+_posts, _pages, _layouts, and _site/assets directories, along with the jrnl.toml
+file, are watched for changes, and the journal is re-published whenever a change
+is made. Any browser tabs open on the site will be reloaded once the journal has
+been re-published.
+
+The files in the _site directory are served as they are, the only addition
+being a small script added to each HTML response for reloading the page.
+
+The -l flag can be given to specify the address to listen on, by default this
+is localhost:8080.
+
+The -v flag will print out which paths were published after each change.`,
+		Run: serveCmd,
+	}
+)
+
+func newWatcher(dirs ...string) (*watcher, error) {
+	w := &watcher{
+		dirs: dirs,
+	}
+
+	files, err := w.scan()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w.files = files
+	return w, nil
+}
+
+func (w *watcher) scan() (map[string]fileStat, error) {
+	files := make(map[string]fileStat)
+
+	for _, dir := range w.dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			files[path] = fileStat{
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			}
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Changed returns the watched directories that have had files created,
+// modified, or removed since the last call to Changed.
+func (w *watcher) Changed() ([]string, error) {
+	files, err := w.scan()
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{})
+
+	for path, stat := range files {
+		if stat0, ok := w.files[path]; !ok || stat0 != stat {
+			set[w.dir(path)] = struct{}{}
+		}
+	}
+
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			set[w.dir(path)] = struct{}{}
+		}
+	}
+
+	w.files = files
+
+	dirs := make([]string, 0, len(set))
+
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+func (w *watcher) dir(path string) string {
+	for _, dir := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (r *reloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for c := range r.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan struct{}, 1)
+
+	r.mu.Lock()
+	r.clients[c] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.clients, c)
+		r.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-c:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == reloadPath {
+		s.reloader.ServeHTTP(w, r)
+		return
+	}
+
+	urlpath := path.Clean("/" + r.URL.Path)
+
+	if strings.HasSuffix(r.URL.Path, "/") {
+		urlpath = path.Join(urlpath, "index.html")
+	}
+
+	if path.Ext(urlpath) != ".html" {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(siteDir, filepath.FromSlash(urlpath)))
+
+	if err != nil {
+		// Let the file server handle any errors, and redirects.
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	i := bytes.LastIndex(b, []byte("</body>"))
+
+	if i < 0 {
+		i = len(b)
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(b[:i])
+	buf.Write(reloadScript)
+	buf.Write(b[i:])
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+func serveCmd(cmd *Command, args []string) {
+	if err := initialized(""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	var (
+		addr    string
+		verbose bool
+	)
+
+	fs := flag.NewFlagSet(cmd.Argv0+" "+args[0], flag.ExitOnError)
+	fs.StringVar(&addr, "l", "localhost:8080", "the address to listen on")
+	fs.BoolVar(&verbose, "v", false, "display the files published after each change")
+	fs.Parse(args[1:])
+
+	// The hash is kept in memory so everything is published on the first run,
+	// and nothing is recorded in the _data/hash file.
+	hash := NewHash()
+
+	build := func() {
+		cfg, err := OpenConfig()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: failed to open config: %s\n", cmd.Argv0, args[0], err)
+			return
+		}
+
+		defer cfg.Close()
+
+		res, err := publish(cfg, hash, publishOptions{drafts: true}, func(err error) {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		})
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+			return
+		}
+
+		if verbose {
+			for _, path := range res.paths {
+				fmt.Println(path)
+			}
+		}
+	}
+
+	build()
+
+	w, err := newWatcher(postsDir, pagesDir, layoutsDir, assetsDir, configFile)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: failed to watch journal: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+
+	rl := &reloader{
+		clients: make(map[chan struct{}]struct{}),
+	}
+
+	go func() {
+		for range time.Tick(500 * time.Millisecond) {
+			dirs, err := w.Changed()
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: failed to watch journal: %s\n", cmd.Argv0, args[0], err)
+				continue
+			}
+
+			if len(dirs) == 0 {
+				continue
+			}
+
+			if verbose {
+				fmt.Println("changes detected in", strings.Join(dirs, ", "))
+			}
+
+			build()
+			rl.Reload()
+		}
+	}()
+
+	srv := &server{
+		files:    http.FileServer(http.Dir(siteDir)),
+		reloader: rl,
+	}
+
+	fmt.Println("serving", siteDir, "on http://"+addr)
+
+	if err := http.ListenAndServe(addr, srv); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", cmd.Argv0, args[0], err)
+		os.Exit(1)
+	}
+}
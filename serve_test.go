@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_ServeHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-serve")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	files := map[string]string{
+		"index.html":           "<html><body>Index</body></html>",
+		"about/index.html":     "<p>About</p>",
+		"assets/style.css":     "body {}",
+		"assets/sub/page.html": "<html><body>Sub</body></html>",
+	}
+
+	for name, body := range files {
+		path := filepath.Join(siteDir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(body), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := &server{
+		files: http.FileServer(http.Dir(siteDir)),
+		reloader: &reloader{
+			clients: make(map[chan struct{}]struct{}),
+		},
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		expected string
+	}{
+		{"/", http.StatusOK, "<html><body>Index" + string(reloadScript) + "</body></html>"},
+		{"/index.html", http.StatusOK, "<html><body>Index" + string(reloadScript) + "</body></html>"},
+		{"/about/", http.StatusOK, "<p>About</p>" + string(reloadScript)},
+		{"/about", http.StatusMovedPermanently, ""},
+		{"/assets/style.css", http.StatusOK, "body {}"},
+		{"/assets/sub/page.html", http.StatusOK, "<html><body>Sub" + string(reloadScript) + "</body></html>"},
+		{"/../index.html", http.StatusOK, "<html><body>Index" + string(reloadScript) + "</body></html>"},
+		{"/missing.html", http.StatusNotFound, ""},
+	}
+
+	for i, test := range tests {
+		rec := httptest.NewRecorder()
+
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.code {
+			t.Fatalf("tests[%d] - unexpected status for %s, expected=%d, got=%d\n", i, test.path, test.code, rec.Code)
+		}
+
+		if test.expected == "" {
+			continue
+		}
+
+		if body := rec.Body.String(); body != test.expected {
+			t.Fatalf("tests[%d] - unexpected body for %s, expected=%q, got=%q\n", i, test.path, test.expected, body)
+		}
+
+		if strings.HasSuffix(test.path, ".css") {
+			continue
+		}
+
+		if typ := rec.Header().Get("Content-Type"); !strings.HasPrefix(typ, "text/html") {
+			t.Fatalf("tests[%d] - unexpected content type for %s, got=%q\n", i, test.path, typ)
+		}
+	}
+}
+
+func Test_WatcherChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-watcher")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	posts := filepath.Join(dir, "_posts")
+	layouts := filepath.Join(dir, "_layouts")
+	config := filepath.Join(dir, "jrnl.toml")
+	missing := filepath.Join(dir, "_pages")
+
+	for _, d := range []string{posts, layouts} {
+		if err := os.MkdirAll(d, os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write := func(path, body string) {
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(body), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(posts, "post.md"), "post")
+	write(filepath.Join(layouts, "post"), "layout")
+	write(config, "[Site]")
+
+	w, err := newWatcher(posts, missing, layouts, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body written to a file changes its size, so the change is seen
+	// even if the modification time is not.
+	tests := []struct {
+		change   func()
+		expected []string
+	}{
+		{func() {}, []string{}},
+		{func() { write(filepath.Join(posts, "category", "new.md"), "new") }, []string{posts}},
+		{func() { write(filepath.Join(layouts, "post"), "changed layout") }, []string{layouts}},
+		{func() { os.Remove(filepath.Join(posts, "post.md")) }, []string{posts}},
+		{func() { write(config, "[Site]\n  Title = \"Changed\"") }, []string{config}},
+		{func() { write(filepath.Join(missing, "about.md"), "about") }, []string{missing}},
+		{
+			func() {
+				write(filepath.Join(posts, "post.md"), "post")
+				write(filepath.Join(layouts, "page"), "page")
+			},
+			[]string{layouts, posts},
+		},
+		{func() {}, []string{}},
+	}
+
+	for i, test := range tests {
+		test.change()
+
+		dirs, err := w.Changed()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(dirs)
+
+		if !reflect.DeepEqual(dirs, test.expected) {
+			t.Fatalf("tests[%d] - unexpected changed dirs, expected=%q, got=%q\n", i, test.expected, dirs)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pkgsftp "github.com/pkg/sftp"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type sftp struct {
+	cli  *pkgsftp.Client
+	path string
+}
+
+func init() {
+	RegisterFS("sftp", openSFTP)
+}
+
+// openSFTP connects to the host of the given sftp:// URL. The host may be an
+// alias for a Host in ~/.ssh/config, in which case the HostName, Port, User,
+// and IdentityFile for that Host are used, unless given in the URL. If no port
+// is given at all then port 22 is used. The identity file to authenticate with
+// can be given via the identity query parameter.
+func openSFTP(u *url.URL) (FS, error) {
+	alias := u.Hostname()
+
+	if alias == "" {
+		return nil, errors.New("missing host in remote url")
+	}
+
+	cfg, err := loadSSHConfig(filepath.Join(os.Getenv("HOME"), ".ssh", "config"))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssh config: %w", err)
+	}
+
+	host := cfg.Get(alias, "HostName")
+
+	if host == "" {
+		host = alias
+	}
+	host = expandSSHTokens(host, alias, "")
+
+	port := u.Port()
+
+	if port == "" {
+		port = cfg.Get(alias, "Port")
+	}
+
+	if port == "" {
+		port = "22"
+	}
+
+	user := u.User.Username()
+
+	if user == "" {
+		user = cfg.Get(alias, "User")
+	}
+
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	path := u.Path
+
+	if path == "" {
+		path = "/home/" + user
+	}
+
+	knownfiles := make([]string, 0)
+
+	for _, files := range cfg.GetAll(alias, "UserKnownHostsFile") {
+		for _, file := range strings.Fields(files) {
+			if file == "none" {
+				continue
+			}
+			knownfiles = append(knownfiles, expandSSHTokens(file, host, user))
+		}
+	}
+
+	if len(knownfiles) == 0 {
+		knownfiles = append(knownfiles, filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"))
+	}
+
+	globalfiles := cfg.Get(alias, "GlobalKnownHostsFile")
+
+	if globalfiles == "" {
+		globalfiles = "/etc/ssh/ssh_known_hosts"
+	}
+	knownfiles = append(knownfiles, strings.Fields(globalfiles)...)
+
+	known, err := openKnownHosts(knownfiles, strings.EqualFold(cfg.Get(alias, "HashKnownHosts"), "yes"))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	addr := net.JoinHostPort(host, port)
+
+	identities := make([]string, 0)
+	required := false
+
+	if identity := u.Query().Get("identity"); identity != "" {
+		identities = append(identities, expandHome(identity))
+		required = true
+	}
+
+	if len(identities) == 0 {
+		for _, identity := range cfg.GetAll(alias, "IdentityFile") {
+			identities = append(identities, expandSSHTokens(identity, host, user))
+		}
+	}
+
+	auth, agentconn := sshAuth(identities, required)
+
+	if agentconn != nil {
+		defer agentconn.Close()
+	}
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{auth},
+		HostKeyCallback:   known.HostKeyCallback,
+		HostKeyAlgorithms: known.Algorithms(addr),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := pkgsftp.NewClient(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftp{
+		cli:  cli,
+		path: path,
+	}, nil
+}
+
+func (s *sftp) filepath(path string) string { return filepath.Join(s.path, path) }
+
+func (s *sftp) Open(path string) (File, error) {
+	path = s.filepath(path)
+
+	if err := s.cli.MkdirAll(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	f, err := s.cli.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err := s.cli.MkdirAll(path); err != nil {
+			return nil, err
+		}
+		f, err = s.cli.Create(path)
+	}
+	return f, err
+}
+
+func (s *sftp) Remove(path string) error {
+	path = s.filepath(path)
+
+	if err := s.cli.Remove(path); err != nil {
+		return err
+	}
+
+	parts := strings.Split(filepath.Dir(path), string(os.PathSeparator))
+
+	for i := range parts {
+		dir := string(os.PathSeparator) + filepath.Join(parts[:len(parts)-i]...)
+
+		if dir == s.path {
+			break
+		}
+
+		info, err := s.cli.ReadDir(dir)
+
+		if err != nil {
+			return err
+		}
+
+		if len(info) == 0 {
+			if err := s.cli.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sftp) Close() error { return s.cli.Close() }
+
+func (s *sftp) ReadFile(path string) ([]byte, error) {
+	f, err := s.cli.Open(s.filepath(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func (s *sftp) Link(oldpath, newpath string) error {
+	newpath = s.filepath(newpath)
+
+	if err := s.cli.MkdirAll(filepath.Dir(newpath)); err != nil {
+		return err
+	}
+	return s.cli.Link(s.filepath(oldpath), newpath)
+}
+
+// Symlink creates the symlink under a temporary name, and then renames it over
+// the given path. This requires the posix-rename@openssh.com extension, since
+// a plain SFTP rename will fail if the path already exists.
+func (s *sftp) Symlink(target, path string) error {
+	path = s.filepath(path)
+	tmp := path + ".tmp"
+
+	if err := s.cli.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := s.cli.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return s.cli.PosixRename(tmp, path)
+}
+
+func (s *sftp) Readlink(path string) (string, error) { return s.cli.ReadLink(s.filepath(path)) }
+
+func (s *sftp) MkdirAll(path string) error { return s.cli.MkdirAll(s.filepath(path)) }
+
+func (s *sftp) ReadDir(path string) ([]string, error) {
+	info, err := s.cli.ReadDir(s.filepath(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(info))
+
+	for _, fi := range info {
+		names = append(names, fi.Name())
+	}
+	return names, nil
+}
+
+// RemoveAll walks the given path and removes every file beneath it, followed
+// by the directories themselves, deepest first.
+func (s *sftp) RemoveAll(path string) error {
+	dirs := make([]string, 0)
+
+	walker := s.cli.Walk(s.filepath(path))
+
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if walker.Stat().IsDir() {
+			dirs = append(dirs, walker.Path())
+			continue
+		}
+
+		if err := s.cli.Remove(walker.Path()); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := s.cli.RemoveDirectory(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+var (
+	sitemapFile = filepath.Join(siteDir, "sitemap.xml")
+	robotsFile  = filepath.Join(siteDir, "robots.txt")
+)
+
+func (sm *sitemap) add(link, href, lastmod string) {
+	sm.URLs = append(sm.URLs, sitemapURL{
+		Loc:     link + href,
+		LastMod: lastmod,
+	})
+}
+
+// publishSitemap writes the sitemap.xml file for the site, listing the site
+// index, the pages, the posts in the given index, and the category and tag
+// indexes if they have been published. If robots is true then a robots.txt
+// file referencing the sitemap is written too.
+func publishSitemap(s Site, index *Index, robots bool) ([]string, error) {
+	link := strings.TrimSuffix(s.Link, "/")
+
+	sm := sitemap{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+
+	if s.layouts.Has("index") {
+		sm.add(link, "/", "")
+	}
+
+	for _, p := range s.Pages {
+		sm.add(link, p.Href(), "")
+	}
+
+	var walkerr error
+
+	index.Walk(func(id string) {
+		if walkerr != nil {
+			return
+		}
+
+		p, ok, err := GetPost(id)
+
+		if err != nil {
+			walkerr = err
+			return
+		}
+
+		if !ok {
+			return
+		}
+
+		lastmod := p.UpdatedAt
+
+		if lastmod.IsZero() {
+			lastmod = p.CreatedAt
+		}
+		sm.add(link, p.Href(), lastmod.Format("2006-01-02"))
+	})
+
+	if walkerr != nil {
+		return nil, walkerr
+	}
+
+	if s.layouts.Has("category-index") {
+		for _, c := range s.Categories {
+			sm.add(link, c.Href(), "")
+		}
+	}
+
+	if s.layouts.Has("tag-index") {
+		for _, t := range s.Tags {
+			sm.add(link, t.Href(), "")
+		}
+	}
+
+	f, err := s.create(sitemapFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if _, err := io.WriteString(f, xml.Header); err != nil {
+		return nil, err
+	}
+
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "\t")
+
+	if err := enc.Encode(sm); err != nil {
+		return nil, err
+	}
+
+	paths := []string{sitemapFile}
+
+	if robots {
+		f, err := s.create(robotsFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		if _, err := fmt.Fprintf(f, "User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml\n", link); err != nil {
+			return nil, err
+		}
+		paths = append(paths, robotsFile)
+	}
+	return paths, nil
+}
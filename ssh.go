@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// sshIdentities is the list of identity files in the ~/.ssh directory that
+// are tried when no identity file is given.
+var sshIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// expandHome replaces a leading ~ in the given path with the user's home
+// directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// decryptIdentity prompts for the passphrase of the given passphrase protected
+// identity file, and decrypts its private key. This will fail if stdin is not
+// a terminal.
+func decryptIdentity(path string, b []byte) (ssh.Signer, error) {
+	fd := int(os.Stdin.Fd())
+
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s: key is passphrase protected, and stdin is not a terminal", path)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", path)
+
+	passphrase, err := term.ReadPassword(fd)
+
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(b, passphrase)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// agentHas reports whether the public key for the given identity file is held
+// by one of the given agent signers. The public key is read from the .pub file
+// next to the identity file.
+func agentHas(signers []ssh.Signer, path string) bool {
+	b, err := ioutil.ReadFile(path + ".pub")
+
+	if err != nil {
+		return false
+	}
+
+	pubkey, _, _, _, err := ssh.ParseAuthorizedKey(b)
+
+	if err != nil {
+		return false
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pubkey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// sshSigners returns a callback for loading the signers used to authenticate
+// with an SSH server. The signers held by the given agent are returned first,
+// followed by the signers for the given identity files. The passphrase of an
+// encrypted identity file is not prompted for if the agent already holds its
+// key. If required is false, then identity files that do not exist are
+// ignored.
+func sshSigners(agentcli agent.Agent, paths []string, required bool) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var agentsigners []ssh.Signer
+
+		if agentcli != nil {
+			// Failing to talk to the agent should not stop the identity
+			// files from being tried.
+			agentsigners, _ = agentcli.Signers()
+		}
+
+		signers := make([]ssh.Signer, 0, len(agentsigners)+len(paths))
+		signers = append(signers, agentsigners...)
+
+		for _, path := range paths {
+			b, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				if os.IsNotExist(err) && !required {
+					continue
+				}
+				return nil, err
+			}
+
+			signer, err := ssh.ParsePrivateKey(b)
+
+			if err != nil {
+				if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+
+				if agentHas(agentsigners, path) {
+					continue
+				}
+
+				signer, err = decryptIdentity(path, b)
+
+				if err != nil {
+					return nil, err
+				}
+			}
+			signers = append(signers, signer)
+		}
+		return signers, nil
+	}
+}
+
+// sshAuth returns the method for authenticating with an SSH server. If
+// SSH_AUTH_SOCK is set then the keys held by the SSH agent are tried first,
+// followed by the given identity files. If no identity files are given then
+// the default identity files in ~/.ssh are tried. If required is true, then
+// the given identity files must exist. The returned connection to the agent,
+// if any, should be closed once the client has connected.
+func sshAuth(identities []string, required bool) (ssh.AuthMethod, net.Conn) {
+	var (
+		conn     net.Conn
+		agentcli agent.Agent
+	)
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		var err error
+
+		conn, err = net.Dial("unix", sock)
+
+		if err == nil {
+			agentcli = agent.NewClient(conn)
+		}
+	}
+
+	if len(identities) == 0 {
+		for _, name := range sshIdentities {
+			identities = append(identities, filepath.Join(os.Getenv("HOME"), ".ssh", name))
+		}
+	}
+	return ssh.PublicKeysCallback(sshSigners(agentcli, identities, required)), conn
+}
+
+// knownHosts checks the keys of the hosts being connected to against the
+// known_hosts files.
+type knownHosts struct {
+	file   string // file is the known_hosts file new keys are added to.
+	hash   bool   // hash denotes whether to hash the hostnames of new keys.
+	lookup ssh.HostKeyCallback
+}
+
+// openKnownHosts parses the given known_hosts files. The first file is the
+// file that the keys of new hosts are added to. Files that do not exist are
+// ignored. If hash is true then the hostnames of new hosts are hashed when
+// added.
+func openKnownHosts(files []string, hash bool) (*knownHosts, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no known_hosts files")
+	}
+
+	existing := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		existing = append(existing, file)
+	}
+
+	kh := &knownHosts{
+		file: files[0],
+		hash: hash,
+	}
+
+	if len(existing) > 0 {
+		lookup, err := knownhosts.New(existing...)
+
+		if err != nil {
+			return nil, err
+		}
+		kh.lookup = lookup
+	}
+	return kh, nil
+}
+
+// known returns the keys known for the given address.
+func (kh *knownHosts) known(address string) []knownhosts.KnownKey {
+	if kh.lookup == nil {
+		return nil
+	}
+
+	// Check a key that will never be known, so the error contains every key
+	// that is known for the host.
+	placeholder, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+
+	if err := kh.lookup(address, &net.TCPAddr{}, placeholder); errors.As(err, &keyErr) {
+		return keyErr.Want
+	}
+	return nil
+}
+
+// Algorithms returns the host key algorithms to use for the given address.
+// If keys are already known for the host, then only the algorithms of those
+// keys are used, so the host does not offer a key of a different type that
+// would then be rejected. The key types are sorted, since the known keys are
+// not returned in any particular order.
+func (kh *knownHosts) Algorithms(address string) []string {
+	// A nil slice is returned when no keys are known, since an empty slice
+	// would leave no algorithms for the client to use.
+	var types []string
+
+	seen := make(map[string]struct{})
+
+	for _, k := range kh.known(address) {
+		typ := k.Key.Type()
+
+		if _, ok := seen[typ]; ok {
+			continue
+		}
+
+		seen[typ] = struct{}{}
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	var algos []string
+
+	for _, typ := range types {
+		// An RSA key can be signed with SHA-2 as well as SHA-1, and hosts
+		// that have disabled SHA-1 will only offer the former.
+		if typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, typ)
+	}
+	return algos
+}
+
+// HostKeyCallback checks the key of the given host against the known keys. If
+// the host is not known then the user is asked whether the key should be
+// trusted, and if so the key is added to the known_hosts file.
+func (kh *knownHosts) HostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if kh.lookup != nil {
+		err := kh.lookup(hostname, remote, key)
+
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			want := keyErr.Want[0]
+
+			return fmt.Errorf("host key for %s does not match the key in %s:%d, the host key may have changed or someone may be impersonating the host", knownhosts.Normalize(hostname), want.Filename, want.Line)
+		}
+	}
+
+	if err := trustHostKey(hostname, key); err != nil {
+		return err
+	}
+	return kh.add(hostname, key)
+}
+
+func (kh *knownHosts) add(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(kh.file), os.FileMode(0700)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(kh.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.FileMode(0600))
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	address := knownhosts.Normalize(hostname)
+
+	if kh.hash {
+		address = knownhosts.HashHostname(address)
+	}
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{address}, key))
+	return err
+}
+
+// trustHostKey asks the user whether the key of the given unknown host should
+// be trusted. This will fail if stdin is not a terminal.
+func trustHostKey(hostname string, key ssh.PublicKey) error {
+	address := knownhosts.Normalize(hostname)
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("host key for %s is not known, and stdin is not a terminal", address)
+	}
+
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\n", address)
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+
+	r := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Fprint(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
+
+		line, err := r.ReadString('\n')
+
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "yes":
+			return nil
+		case "no":
+			return fmt.Errorf("host key for %s was not trusted", address)
+		}
+	}
+}
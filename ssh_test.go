@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newEd25519Key(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECDSAKey(t *testing.T) ssh.PublicKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(&priv.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T) ssh.PublicKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(&priv.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func Test_KnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-known-hosts")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ed25519Key := newEd25519Key(t)
+	ecdsaKey := newECDSAKey(t)
+	portKey := newEd25519Key(t)
+	globalKey := newEd25519Key(t)
+	unknownKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t)
+
+	user := filepath.Join(dir, "known_hosts")
+	global := filepath.Join(dir, "ssh_known_hosts")
+
+	lines := []string{
+		knownhosts.Line([]string{knownhosts.HashHostname("example.com")}, ed25519Key),
+		knownhosts.Line([]string{"example.com"}, ecdsaKey),
+		knownhosts.Line([]string{"example.com"}, newEd25519Key(t)),
+		knownhosts.Line([]string{knownhosts.Normalize("example.org:2222")}, portKey),
+		knownhosts.Line([]string{"rsa.example.com"}, rsaKey),
+		knownhosts.Line([]string{"rsa.example.com"}, newEd25519Key(t)),
+	}
+
+	if err := ioutil.WriteFile(user, []byte(strings.Join(lines, "\n")+"\n"), os.FileMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(global, []byte(knownhosts.Line([]string{"example.net"}, globalKey)+"\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	kh, err := openKnownHosts([]string{user, global, filepath.Join(dir, "missing")}, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown hosts are not checked here, since the user would be asked
+	// whether to trust them. Instead, no algorithms are known for them.
+	tests := []struct {
+		address string
+		key     ssh.PublicKey
+		err     string
+	}{
+		{"example.com:22", ed25519Key, ""},
+		{"example.com:22", ecdsaKey, ""},
+		{"example.com:22", unknownKey, "does not match"},
+		{"example.org:2222", portKey, ""},
+		{"example.net:22", globalKey, ""},
+		{"rsa.example.com:22", rsaKey, ""},
+		{"example.net:22", ed25519Key, "does not match"},
+	}
+
+	for i, test := range tests {
+		err := kh.HostKeyCallback(test.address, &net.TCPAddr{}, test.key)
+
+		if test.err == "" {
+			if err != nil {
+				t.Fatalf("tests[%d] - unexpected error for %s: %s\n", i, test.address, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("tests[%d] - expected error containing %q for %s, got=%v\n", i, test.err, test.address, err)
+		}
+	}
+
+	algotests := []struct {
+		address  string
+		expected []string
+	}{
+		{"example.com:22", []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519}},
+		{"example.org:2222", []string{ssh.KeyAlgoED25519}},
+		{"rsa.example.com:22", []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{"example.org:22", nil},
+		{"unknown.com:22", nil},
+	}
+
+	for i, test := range algotests {
+		if algos := kh.Algorithms(test.address); !reflect.DeepEqual(algos, test.expected) {
+			t.Fatalf("algotests[%d] - unexpected algorithms for %s, expected=%q, got=%q\n", i, test.address, test.expected, algos)
+		}
+	}
+
+	if err := kh.add("new.example.com:2222", unknownKey); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(user)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), "new.example.com") {
+		t.Fatalf("expected hostname of new key to be hashed, got=%q\n", string(b))
+	}
+
+	kh, err = openKnownHosts([]string{user, global}, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kh.HostKeyCallback("new.example.com:2222", &net.TCPAddr{}, unknownKey); err != nil {
+		t.Fatalf("unexpected error for added key: %s\n", err)
+	}
+
+	kh, err = openKnownHosts([]string{filepath.Join(dir, "missing")}, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if algos := kh.Algorithms("example.com:22"); algos != nil {
+		t.Fatalf("expected no algorithms without known_hosts files, got=%q\n", algos)
+	}
+}
+
+// writeIdentity writes the given private key to an identity file in the given
+// directory, along with its .pub file. The identity file is encrypted if a
+// passphrase is given.
+func writeIdentity(t *testing.T, dir, name string, priv ed25519.PrivateKey, passphrase string) string {
+	var (
+		block *pem.Block
+		err   error
+	)
+
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, name)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, name, []byte(passphrase))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), os.FileMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ssh.NewPublicKey(priv.Public())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(pub), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_SSHSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-ssh-signers")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Stdin is replaced with a file, so it is never a terminal, and the
+	// passphrase of an encrypted identity can never be prompted for.
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	os.Stdin, err = os.Open(os.DevNull)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Stdin.Close()
+
+	_, encryptedKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, plainKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := writeIdentity(t, dir, "encrypted", encryptedKey, "secret")
+	plain := writeIdentity(t, dir, "plain", plainKey, "")
+	missing := filepath.Join(dir, "missing")
+
+	keyring := agent.NewKeyring()
+
+	if err := keyring.Add(agent.AddedKey{PrivateKey: encryptedKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		agent    agent.Agent
+		paths    []string
+		required bool
+		signers  int
+		err      string
+	}{
+		{nil, []string{plain}, true, 1, ""},
+		{nil, []string{encrypted}, true, 0, "stdin is not a terminal"},
+		{nil, []string{missing, plain}, false, 1, ""},
+		{nil, []string{missing, plain}, true, 0, "no such file"},
+		{keyring, []string{encrypted, plain}, true, 2, ""},
+		{keyring, []string{missing}, false, 1, ""},
+	}
+
+	for i, test := range tests {
+		signers, err := sshSigners(test.agent, test.paths, test.required)()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("tests[%d] - expected error containing %q, got=%v\n", i, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if len(signers) != test.signers {
+			t.Fatalf("tests[%d] - unexpected number of signers, expected=%d, got=%d\n", i, test.signers, len(signers))
+		}
+	}
+}
+
+func Test_AgentHas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-agent-has")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	_, heldKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := writeIdentity(t, dir, "held", heldKey, "secret")
+	other := writeIdentity(t, dir, "other", otherKey, "secret")
+	nopub := writeIdentity(t, dir, "nopub", heldKey, "secret")
+
+	if err := os.Remove(nopub + ".pub"); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := agent.NewKeyring()
+
+	if err := keyring.Add(agent.AddedKey{PrivateKey: heldKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	signers, err := keyring.Signers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{held, true},
+		{other, false},
+		{nopub, false},
+	}
+
+	for i, test := range tests {
+		if has := agentHas(signers, test.path); has != test.expected {
+			t.Fatalf("tests[%d] - unexpected result for %s, expected=%v, got=%v\n", i, filepath.Base(test.path), test.expected, has)
+		}
+	}
+}
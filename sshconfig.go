@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sshHost is a Host block in an ssh_config file.
+type sshHost struct {
+	patterns []string
+	params   [][2]string
+}
+
+// sshConfig is a parsed ssh_config file. Only Host blocks are supported, Match
+// blocks are ignored.
+type sshConfig struct {
+	hosts []*sshHost
+}
+
+// maxIncludeDepth is the maximum depth of Include directives that will be
+// followed when parsing an ssh_config file.
+const maxIncludeDepth = 16
+
+// loadSSHConfig parses the ssh_config file at the given path. If the file does
+// not exist then an empty config is returned.
+func loadSSHConfig(path string) (*sshConfig, error) {
+	cfg := &sshConfig{}
+
+	global := &sshHost{patterns: []string{"*"}}
+
+	cfg.hosts = append(cfg.hosts, global)
+
+	if err := cfg.parse(path, global, 0); err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// splitSSHLine splits the given line of an ssh_config file into its keyword and
+// arguments. Arguments may be quoted, and the keyword may be separated from
+// the arguments by an equals sign.
+func splitSSHLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+
+	i := strings.IndexAny(line, " \t=")
+
+	if i < 0 {
+		return strings.ToLower(line), nil
+	}
+
+	key := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	args := make([]string, 0)
+
+	var (
+		buf    strings.Builder
+		quoted bool
+	)
+
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if buf.Len() > 0 {
+				args = append(args, buf.String())
+				buf.Reset()
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	if buf.Len() > 0 {
+		args = append(args, buf.String())
+	}
+	return key, args
+}
+
+func (c *sshConfig) parse(name string, cur *sshHost, depth int) error {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+
+	for sc.Scan() {
+		key, args := splitSSHLine(sc.Text())
+
+		if key == "" || len(args) == 0 {
+			continue
+		}
+
+		switch key {
+		case "host":
+			cur = &sshHost{patterns: args}
+			c.hosts = append(c.hosts, cur)
+		case "match":
+			cur = &sshHost{}
+			c.hosts = append(c.hosts, cur)
+		case "include":
+			if depth >= maxIncludeDepth {
+				continue
+			}
+
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+				}
+
+				matches, err := filepath.Glob(pattern)
+
+				if err != nil {
+					return err
+				}
+
+				for _, match := range matches {
+					if err := c.parse(match, cur, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			cur.params = append(cur.params, [2]string{key, strings.Join(args, " ")})
+		}
+	}
+	return sc.Err()
+}
+
+// match reports whether the given host matches the patterns of the Host block.
+// A host matches if it matches any of the patterns, and none of the negated
+// patterns.
+func (h *sshHost) match(host string) bool {
+	matched := false
+
+	for _, pattern := range h.patterns {
+		negate := strings.HasPrefix(pattern, "!")
+
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		if ok, _ := path.Match(pattern, host); ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// GetAll returns every value of the given keyword for the given host, in the
+// order they appear in the config.
+func (c *sshConfig) GetAll(host, key string) []string {
+	key = strings.ToLower(key)
+
+	vals := make([]string, 0)
+
+	for _, h := range c.hosts {
+		if !h.match(host) {
+			continue
+		}
+
+		for _, param := range h.params {
+			if param[0] == key {
+				vals = append(vals, param[1])
+			}
+		}
+	}
+	return vals
+}
+
+// Get returns the value of the given keyword for the given host. As with ssh,
+// the first value obtained for the keyword is used.
+func (c *sshConfig) Get(host, key string) string {
+	if vals := c.GetAll(host, key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// expandSSHTokens expands the tokens in the given ssh_config value, such as the
+// value of IdentityFile. The supported tokens are %d for the home directory,
+// %h for the remote host, %r for the remote user, %u for the local user, and
+// %% for a literal percent sign.
+func expandSSHTokens(s, host, remoteUser string) string {
+	local := os.Getenv("USER")
+
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+
+	r := strings.NewReplacer(
+		"%%", "%",
+		"%d", os.Getenv("HOME"),
+		"%h", host,
+		"%r", remoteUser,
+		"%u", local,
+	)
+	return expandHome(r.Replace(s))
+}
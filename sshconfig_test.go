@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_SplitSSHLine(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		args []string
+	}{
+		{"", "", nil},
+		{"   ", "", nil},
+		{"# Host example", "", nil},
+		{"Host example", "host", []string{"example"}},
+		{"  HostName\texample.com", "hostname", []string{"example.com"}},
+		{"Port=2222", "port", []string{"2222"}},
+		{"Port = 2222", "port", []string{"2222"}},
+		{"User =me", "user", []string{"me"}},
+		{"Host web-* !web-internal", "host", []string{"web-*", "!web-internal"}},
+		{`IdentityFile "~/.ssh/my key"`, "identityfile", []string{"~/.ssh/my key"}},
+		{`ProxyCommand ssh -W "%h:%p" bastion`, "proxycommand", []string{"ssh", "-W", "%h:%p", "bastion"}},
+		{"Compression", "compression", nil},
+	}
+
+	for i, test := range tests {
+		key, args := splitSSHLine(test.line)
+
+		if key != test.key {
+			t.Fatalf("tests[%d] - unexpected key for %q, expected=%q, got=%q\n", i, test.line, test.key, key)
+		}
+
+		if len(args) == 0 && len(test.args) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(args, test.args) {
+			t.Fatalf("tests[%d] - unexpected args for %q, expected=%q, got=%q\n", i, test.line, test.args, args)
+		}
+	}
+}
+
+func Test_SSHHostMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		expected bool
+	}{
+		{[]string{"*"}, "example.com", true},
+		{[]string{"example.com"}, "example.com", true},
+		{[]string{"example.com"}, "example.org", false},
+		{[]string{"*.example.com"}, "www.example.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"web-?"}, "web-1", true},
+		{[]string{"web-?"}, "web-10", false},
+		{[]string{"foo", "bar"}, "bar", true},
+		{[]string{"web-*", "!web-internal"}, "web-public", true},
+		{[]string{"web-*", "!web-internal"}, "web-internal", false},
+		{[]string{"!web-internal", "web-*"}, "web-internal", false},
+		{[]string{"!web-internal"}, "web-public", false},
+	}
+
+	for i, test := range tests {
+		h := &sshHost{patterns: test.patterns}
+
+		if matched := h.match(test.host); matched != test.expected {
+			t.Fatalf("tests[%d] - unexpected match of %q against %q, expected=%v, got=%v\n", i, test.host, test.patterns, test.expected, matched)
+		}
+	}
+}
+
+func Test_SSHConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-sshconfig")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config": `User global
+
+Host example.com
+	HostName 10.0.0.1
+	Include ` + filepath.Join(dir, "include.d", "*") + `
+	IdentityFile ~/.ssh/example
+
+Host *.example.com !internal.example.com
+	User web
+
+Host *
+	User fallback
+	Port 22
+	IdentityFile ~/.ssh/id_ed25519
+`,
+		"include.d/port": `Port 2222
+IdentityFile ~/.ssh/included
+`,
+		// loop includes itself, and should be followed no deeper than
+		// maxIncludeDepth.
+		"loop": `Include ` + filepath.Join(dir, "loop") + `
+User loop
+`,
+	}
+
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(body), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := loadSSHConfig(filepath.Join(dir, "config"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host     string
+		key      string
+		expected string
+	}{
+		{"example.com", "HostName", "10.0.0.1"},
+		{"example.com", "User", "global"},
+		{"example.com", "Port", "2222"},
+		{"example.com", "IdentityFile", "~/.ssh/included"},
+		{"www.example.com", "User", "global"},
+		{"www.example.com", "Port", "22"},
+		{"internal.example.com", "Port", "22"},
+		{"example.org", "HostName", ""},
+	}
+
+	for i, test := range tests {
+		if val := cfg.Get(test.host, test.key); val != test.expected {
+			t.Fatalf("tests[%d] - unexpected %s for %q, expected=%q, got=%q\n", i, test.key, test.host, test.expected, val)
+		}
+	}
+
+	expected := []string{"~/.ssh/included", "~/.ssh/example", "~/.ssh/id_ed25519"}
+
+	if vals := cfg.GetAll("example.com", "identityfile"); !reflect.DeepEqual(vals, expected) {
+		t.Fatalf("unexpected IdentityFile values, expected=%q, got=%q\n", expected, vals)
+	}
+
+	cfg, err = loadSSHConfig(filepath.Join(dir, "loop"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vals := cfg.GetAll("example.com", "user"); len(vals) != maxIncludeDepth+1 {
+		t.Fatalf("unexpected number of User values, expected=%d, got=%d\n", maxIncludeDepth+1, len(vals))
+	}
+
+	cfg, err = loadSSHConfig(filepath.Join(dir, "missing"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val := cfg.Get("example.com", "User"); val != "" {
+		t.Fatalf("unexpected User for missing config, expected=%q, got=%q\n", "", val)
+	}
+}
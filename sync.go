@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// syncFS is an FS that files can be read back from, so the checksums of the
+// files on the remote can be read from the remote itself.
+type syncFS interface {
+	FS
+
+	// ReadFile returns the contents of the file at the given path. If the
+	// file does not exist then the returned error satisfies os.IsNotExist.
+	ReadFile(path string) ([]byte, error)
+}
+
+// syncManifest is the file on the remote that records the size and checksum
+// of every file copied to the remote when syncing.
+const syncManifest = ".jrnl-manifest"
+
+// syncEntry is the size and SHA256 checksum of a file.
+type syncEntry struct {
+	size int64
+	sum  string
+}
+
+// errSyncChanged is returned when the manifest on the remote was changed by
+// another publish after the sync was planned.
+var errSyncChanged = errors.New(syncManifest + " was changed on the remote by another publish")
+
+// syncPlan is the set of changes needed to make the remote match the _site
+// directory. Paths are relative to the _site directory, with forward slashes,
+// as they are recorded in the manifest on the remote.
+//
+// Syncing is not atomic. The manifest read when planning is checked before
+// anything is removed from the remote, and again before the manifest is
+// written, so a publish from another machine in the meantime is detected
+// rather than overwritten. However, the files copied by both publishes may
+// still be interleaved on the remote, and a publish between the last check
+// and the write can still be lost, so publishes to the same remote should not
+// be run at the same time.
+type syncPlan struct {
+	// manifest is the manifest on the remote as it was read when the sync
+	// was planned.
+	manifest []byte
+
+	remote map[string]syncEntry
+	local  map[string]syncEntry
+
+	copy   []string
+	remove []string
+}
+
+func (r *Remote) syncFS() (syncFS, error) {
+	fs, ok := r.fs.(syncFS)
+
+	if !ok {
+		return nil, errors.New("remote does not support syncing")
+	}
+	return fs, nil
+}
+
+// ReadFile returns the contents of the given file on the remote.
+func (r *Remote) ReadFile(path string) ([]byte, error) {
+	fs, err := r.syncFS()
+
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(path)
+}
+
+// WriteFile writes the given contents to the given file on the remote.
+func (r *Remote) WriteFile(path string, b []byte) error {
+	f, err := r.fs.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// decodeSyncManifest decodes the given manifest. Each line of the manifest is
+// the checksum, size, and path of a file.
+func decodeSyncManifest(b []byte) (map[string]syncEntry, error) {
+	m := make(map[string]syncEntry)
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 3)
+
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", syncManifest, n)
+		}
+
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed size", syncManifest, n)
+		}
+
+		m[parts[2]] = syncEntry{
+			size: size,
+			sum:  parts[0],
+		}
+	}
+	return m, sc.Err()
+}
+
+// encodeSyncManifest encodes the given manifest, sorted by path.
+func encodeSyncManifest(m map[string]syncEntry) []byte {
+	paths := make([]string, 0, len(m))
+
+	for path := range m {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "%s %d %s\n", m[path].sum, m[path].size, path)
+	}
+	return buf.Bytes()
+}
+
+func checksum(path string) (syncEntry, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return syncEntry{}, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	n, err := io.Copy(h, f)
+
+	if err != nil {
+		return syncEntry{}, err
+	}
+
+	return syncEntry{
+		size: n,
+		sum:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// syncKey returns the path of the given output as it is recorded in the
+// manifest on the remote.
+func syncKey(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(siteDir)+"/")
+}
+
+// syncPath returns the path of the output for the given key of the manifest.
+func syncPath(key string) string {
+	return filepath.Join(siteDir, filepath.FromSlash(key))
+}
+
+// planSync compares the given outputs to the manifest on the remote. The
+// outputs that are missing from the remote, or that differ in size or checksum
+// are to be copied, and the files in the manifest that are no longer outputs
+// are to be removed. If dir is not empty, then outputs are read from there
+// first, as they are during a dry run.
+func planSync(rem *Remote, outputs []string, dir string) (*syncPlan, error) {
+	b, err := rem.ReadFile(syncManifest)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", syncManifest, err)
+	}
+
+	remote, err := decodeSyncManifest(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &syncPlan{
+		manifest: b,
+		remote:   remote,
+		local:    make(map[string]syncEntry),
+		copy:     make([]string, 0),
+		remove:   make([]string, 0),
+	}
+
+	for _, path := range outputs {
+		key := syncKey(path)
+
+		if _, ok := plan.local[key]; ok {
+			continue
+		}
+
+		src := path
+
+		if dir != "" {
+			if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+				src = filepath.Join(dir, path)
+			}
+		}
+
+		ent, err := checksum(src)
+
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+
+			// An output missing from the _site directory, such as a page
+			// that failed to publish in a fresh clone of the journal, is
+			// left as is on the remote.
+			if ent, ok := remote[key]; ok {
+				plan.local[key] = ent
+			}
+			continue
+		}
+
+		plan.local[key] = ent
+
+		if ent != remote[key] {
+			plan.copy = append(plan.copy, path)
+		}
+	}
+
+	for key := range remote {
+		if _, ok := plan.local[key]; !ok {
+			plan.remove = append(plan.remove, syncPath(key))
+		}
+	}
+
+	sort.Strings(plan.copy)
+	sort.Strings(plan.remove)
+	return plan, nil
+}
+
+// Copied records the given output as copied to the remote.
+func (p *syncPlan) Copied(path string) {
+	key := syncKey(path)
+	p.remote[key] = p.local[key]
+}
+
+// Removed records the given file as removed from the remote.
+func (p *syncPlan) Removed(path string) { delete(p.remote, syncKey(path)) }
+
+// Check returns errSyncChanged if the manifest on the remote has changed since
+// the sync was planned.
+func (p *syncPlan) Check(rem *Remote) error {
+	b, err := rem.ReadFile(syncManifest)
+
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", syncManifest, err)
+	}
+
+	if !bytes.Equal(b, p.manifest) {
+		return errSyncChanged
+	}
+	return nil
+}
+
+// Save writes the manifest of what has been copied to the remote to the
+// remote. Nothing is written if the manifest on the remote has changed since
+// the sync was planned.
+func (p *syncPlan) Save(rem *Remote) error {
+	if err := p.Check(rem); err != nil {
+		return err
+	}
+
+	b := encodeSyncManifest(p.remote)
+
+	if err := rem.WriteFile(syncManifest, b); err != nil {
+		return err
+	}
+
+	p.manifest = b
+	return nil
+}
+
+// unsync removes the given files from the manifest on the remote, if there is
+// one, after they have been removed from the remote by other means.
+func unsync(rem *Remote, paths []string) error {
+	if _, err := rem.syncFS(); err != nil {
+		return nil
+	}
+
+	b, err := rem.ReadFile(syncManifest)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	m, err := decodeSyncManifest(b)
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		delete(m, syncKey(path))
+	}
+	return rem.WriteFile(syncManifest, encodeSyncManifest(m))
+}
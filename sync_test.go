@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_SyncManifest(t *testing.T) {
+	m := map[string]syncEntry{
+		"index.html":          {size: 10, sum: "aaaa"},
+		"2006-01-02/a b.html": {size: 0, sum: "bbbb"},
+	}
+
+	b := encodeSyncManifest(m)
+
+	expected := "bbbb 0 2006-01-02/a b.html\naaaa 10 index.html\n"
+
+	if string(b) != expected {
+		t.Fatalf("unexpected manifest, expected=%q, got=%q\n", expected, string(b))
+	}
+
+	decoded, err := decodeSyncManifest(b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, m) {
+		t.Fatalf("unexpected decoded manifest, expected=%v, got=%v\n", m, decoded)
+	}
+
+	for i, manifest := range []string{"aaaa 10\n", "aaaa ten index.html\n"} {
+		if _, err := decodeSyncManifest([]byte(manifest)); err == nil {
+			t.Fatalf("tests[%d] - expected error for malformed manifest %q\n", i, manifest)
+		}
+	}
+}
+
+func Test_PlanSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-sync")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	files := map[string]string{
+		"same.html":    "same",
+		"changed.html": "changed",
+		"new.html":     "new",
+	}
+
+	if err := os.MkdirAll(siteDir, os.FileMode(0755)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(siteDir, name), []byte(body), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	same, err := checksum(filepath.Join(siteDir, "same.html"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := map[string]syncEntry{
+		"same.html":    same,
+		"changed.html": {size: 7, sum: "stale"},
+		"missing.html": {size: 1, sum: "missing"},
+		"removed.html": {size: 1, sum: "removed"},
+	}
+
+	rem, err := OpenRemote(filepath.Join(dir, "remote"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rem.WriteFile(syncManifest, encodeSyncManifest(remote)); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := []string{
+		filepath.Join(siteDir, "same.html"),
+		filepath.Join(siteDir, "changed.html"),
+		filepath.Join(siteDir, "new.html"),
+		// missing.html failed to publish, so should be left on the remote.
+		filepath.Join(siteDir, "missing.html"),
+	}
+
+	plan, err := planSync(rem, outputs, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join(siteDir, "changed.html"), filepath.Join(siteDir, "new.html")}
+
+	if !reflect.DeepEqual(plan.copy, expected) {
+		t.Fatalf("unexpected files to copy, expected=%q, got=%q\n", expected, plan.copy)
+	}
+
+	expected = []string{filepath.Join(siteDir, "removed.html")}
+
+	if !reflect.DeepEqual(plan.remove, expected) {
+		t.Fatalf("unexpected files to remove, expected=%q, got=%q\n", expected, plan.remove)
+	}
+
+	for _, path := range plan.copy {
+		plan.Copied(path)
+	}
+
+	for _, path := range plan.remove {
+		plan.Removed(path)
+	}
+
+	if err := plan.Save(rem); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plan saved the manifest itself, so a second save is not seen as
+	// another publish changing the manifest.
+	if err := plan.Save(rem); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = planSync(rem, outputs, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.copy) != 0 || len(plan.remove) != 0 {
+		t.Fatalf("expected nothing to sync, got copy=%q, remove=%q\n", plan.copy, plan.remove)
+	}
+
+	// Another publish changes the manifest after the sync was planned.
+	other, err := planSync(rem, outputs, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.Removed(filepath.Join(siteDir, "missing.html"))
+
+	if err := other.Save(rem); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := rem.ReadFile(syncManifest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := plan.Check(rem); err != errSyncChanged {
+		t.Fatalf("expected error %q, got=%v\n", errSyncChanged, err)
+	}
+
+	if err := plan.Save(rem); err != errSyncChanged {
+		t.Fatalf("expected error %q, got=%v\n", errSyncChanged, err)
+	}
+
+	after, err := rem.ReadFile(syncManifest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(after) != string(b) {
+		t.Fatalf("expected manifest to be left as is, expected=%q, got=%q\n", string(b), string(after))
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// webdav is an FS for a directory on a WebDAV server. Directories are created
+// with MKCOL as files are uploaded into them, and are removed once empty.
+type webdav struct {
+	cli      *http.Client
+	endpoint *url.URL
+	username string
+	password string
+}
+
+// webdavFile is a file being written to a WebDAV server. The file is uploaded
+// when closed.
+type webdavFile struct {
+	bytes.Buffer
+
+	fs   *webdav
+	path string
+}
+
+type webdavError struct {
+	method string
+	status int
+	body   string
+}
+
+// multistatus is the body of the response to a PROPFIND request, only the
+// href of each resource is decoded.
+type multistatus struct {
+	Responses []struct {
+		Href string `xml:"DAV: href"`
+	} `xml:"DAV: response"`
+}
+
+func init() {
+	RegisterFS("dav", openWebDAV)
+	RegisterFS("davs", openWebDAV)
+	RegisterFS("webdav", openWebDAV)
+	RegisterFS("webdavs", openWebDAV)
+}
+
+// openWebDAV opens the directory on the WebDAV server for the given dav:// or
+// davs:// URL, the latter of which uses HTTPS. The webdav:// and webdavs://
+// schemes are the same as dav:// and davs://. The credentials for basic auth
+// can be given in the URL, otherwise they are taken from the
+// JRNL_WEBDAV_USERNAME and JRNL_WEBDAV_PASSWORD environment variables.
+func openWebDAV(u *url.URL) (FS, error) {
+	if u.Host == "" {
+		return nil, errors.New("missing host in remote url")
+	}
+
+	endpoint := *u
+	endpoint.Scheme = "http"
+	endpoint.User = nil
+	endpoint.Path = path.Join("/", u.Path)
+	endpoint.RawPath = ""
+	endpoint.Fragment = ""
+
+	if u.Scheme == "davs" || u.Scheme == "webdavs" {
+		endpoint.Scheme = "https"
+	}
+
+	fs := &webdav{
+		cli:      &http.Client{Timeout: time.Minute},
+		endpoint: &endpoint,
+		username: u.User.Username(),
+	}
+
+	if password, ok := u.User.Password(); ok {
+		fs.password = password
+	}
+
+	if fs.username == "" {
+		fs.username = os.Getenv("JRNL_WEBDAV_USERNAME")
+		fs.password = os.Getenv("JRNL_WEBDAV_PASSWORD")
+	}
+	return fs, nil
+}
+
+func (e *webdavError) Error() string {
+	return fmt.Sprintf("webdav: unexpected status %d for %s: %s", e.status, e.method, e.body)
+}
+
+// url returns the URL of the resource for the given path. Collections, or
+// directories, are given a trailing slash.
+func (d *webdav) url(p string, collection bool) *url.URL {
+	u := *d.endpoint
+	u.Path = path.Join(u.Path, filepath.ToSlash(p))
+
+	if collection {
+		u.Path += "/"
+	}
+	return &u
+}
+
+func (d *webdav) do(method string, u *url.URL, body []byte, hdr http.Header) (*http.Response, error) {
+	r, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range hdr {
+		r.Header[k] = v
+	}
+
+	if d.username != "" {
+		r.SetBasicAuth(d.username, d.password)
+	}
+
+	resp, err := d.cli.Do(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		return nil, &webdavError{
+			method: method,
+			status: resp.StatusCode,
+			body:   strings.TrimSpace(string(b)),
+		}
+	}
+	return resp, nil
+}
+
+// webdavStatus returns the status of the given webdavError, or 0 if the error
+// is not a webdavError.
+func webdavStatus(err error) int {
+	var davErr *webdavError
+
+	if errors.As(err, &davErr) {
+		return davErr.status
+	}
+	return 0
+}
+
+func (d *webdav) send(method string, u *url.URL, body []byte, hdr http.Header) error {
+	resp, err := d.do(method, u, body, hdr)
+
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// mkdirAll creates the given directory, along with any parents, beneath the
+// remote directory. A 405 Method Not Allowed response to MKCOL means the
+// directory already exists.
+func (d *webdav) mkdirAll(dir string) error {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+
+	if dir == "" || dir == "." {
+		return nil
+	}
+
+	parts := strings.Split(dir, "/")
+
+	for i := range parts {
+		err := d.send("MKCOL", d.url(strings.Join(parts[:i+1], "/"), true), nil, nil)
+
+		if err != nil && webdavStatus(err) != http.StatusMethodNotAllowed {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDir returns the hrefs of the resources in the given directory.
+func (d *webdav) readDir(dir string) ([]string, error) {
+	u := d.url(dir, true)
+
+	hdr := make(http.Header)
+	hdr.Set("Depth", "1")
+	hdr.Set("Content-Type", "application/xml; charset=utf-8")
+
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`)
+
+	resp, err := d.do("PROPFIND", u, body, hdr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var ms multistatus
+
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	hrefs := make([]string, 0, len(ms.Responses))
+
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The directory itself is included in the response.
+		if strings.TrimSuffix(href.Path, "/") == strings.TrimSuffix(u.Path, "/") {
+			continue
+		}
+		hrefs = append(hrefs, href.Path)
+	}
+	return hrefs, nil
+}
+
+func (d *webdav) Open(path string) (File, error) {
+	return &webdavFile{
+		fs:   d,
+		path: path,
+	}, nil
+}
+
+// Remove deletes the given file, and then deletes each parent directory that
+// is left empty, up to the remote directory itself.
+func (d *webdav) Remove(p string) error {
+	if err := d.send(http.MethodDelete, d.url(p, false), nil, nil); err != nil {
+		if webdavStatus(err) == http.StatusNotFound {
+			return &os.PathError{Op: "remove", Path: p, Err: os.ErrNotExist}
+		}
+		return err
+	}
+
+	dir := path.Dir(strings.Trim(filepath.ToSlash(p), "/"))
+
+	for dir != "." {
+		hrefs, err := d.readDir(dir)
+
+		if err != nil {
+			return err
+		}
+
+		if len(hrefs) > 0 {
+			break
+		}
+
+		if err := d.send(http.MethodDelete, d.url(dir, true), nil, nil); err != nil {
+			return err
+		}
+		dir = path.Dir(dir)
+	}
+	return nil
+}
+
+func (d *webdav) Close() error { return nil }
+
+func (d *webdav) ReadFile(p string) ([]byte, error) {
+	resp, err := d.do(http.MethodGet, d.url(p, false), nil, nil)
+
+	if err != nil {
+		if webdavStatus(err) == http.StatusNotFound {
+			return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Close uploads the contents of the file to the server. If the directory of
+// the file does not exist then it is created, and the upload is retried.
+func (f *webdavFile) Close() error {
+	b := f.Bytes()
+
+	typ := mime.TypeByExtension(filepath.Ext(f.path))
+
+	if typ == "" {
+		typ = http.DetectContentType(b)
+	}
+
+	hdr := make(http.Header)
+	hdr.Set("Content-Type", typ)
+
+	u := f.fs.url(f.path, false)
+
+	err := f.fs.send(http.MethodPut, u, b, hdr)
+
+	// A 409 Conflict is returned when the parent of the resource does not
+	// exist, though some servers return a 404 Not Found instead.
+	if code := webdavStatus(err); code != http.StatusConflict && code != http.StatusNotFound {
+		return err
+	}
+
+	if err := f.fs.mkdirAll(path.Dir(filepath.ToSlash(f.path))); err != nil {
+		return err
+	}
+	return f.fs.send(http.MethodPut, u, b, hdr)
+}
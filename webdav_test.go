@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	davserver "golang.org/x/net/webdav"
+)
+
+func Test_WebDAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jrnl-webdav")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "blog"), os.FileMode(0755)); err != nil {
+		t.Fatal(err)
+	}
+
+	dav := &davserver.Handler{
+		FileSystem: davserver.Dir(dir),
+		LockSystem: davserver.NewMemLS(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "me" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(strings.Replace(srv.URL, "http://", "dav://me:secret@", 1) + "/blog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := remotefs["dav"](u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fs.Close()
+
+	files := map[string]string{
+		"/index.html":                  "<html></html>",
+		"/assets/style.css":            "body {}",
+		"/programming/2021/index.html": "<html>programming</html>",
+	}
+
+	writeFiles(t, fs, files)
+
+	for path, body := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "blog", filepath.FromSlash(path)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != body {
+			t.Fatalf("unexpected contents of %q, expected=%q, got=%q\n", path, body, string(b))
+		}
+	}
+
+	if err := fs.Remove("/programming/2021/index.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "blog", "programming")); !os.IsNotExist(err) {
+		t.Fatalf("expected empty directory %q to be removed\n", "programming")
+	}
+
+	if err := fs.Remove("/assets/missing.css"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got=%v\n", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "blog", "assets", "style.css")); err != nil {
+		t.Fatal(err)
+	}
+
+	u.User = url.UserPassword("me", "invalid")
+
+	fs, err = remotefs["dav"](u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Remove("/index.html"); err == nil {
+		t.Fatal("expected error for invalid credentials")
+	}
+}